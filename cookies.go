@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"intelirest-cli/runtime"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cookiesCmd = &cobra.Command{
+	Use:   "cookies",
+	Short: "Manage the persisted cookie jar",
+}
+
+var cookiesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cookies of the cookie file",
+	Args:  cobra.NoArgs,
+	RunE:  listCookies,
+}
+
+var cookiesClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all cookies of the cookie file",
+	Args:  cobra.NoArgs,
+	RunE:  clearCookies,
+}
+
+// listCookies prints the cookies of the cookie file. Their values are redacted unless --no-redact is given.
+func listCookies(cmd *cobra.Command, _ []string) error {
+	noRedact, err := cmd.Flags().GetBool("no-redact")
+	if err != nil {
+		return err
+	}
+	jar, err := runtime.ReadCookieJar(viper.GetString("cookie-file"))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tPATH\tNAME\tVALUE\tEXPIRES\tSECURE\tHTTPONLY")
+	for _, c := range jar.All() {
+		value := runtime.Redacted
+		if noRedact {
+			value = c.Value
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%t\n", c.Domain, c.Path, c.Name, value, runtime.CookieExpiry(c), c.Secure, c.HttpOnly)
+	}
+
+	return w.Flush()
+}
+
+func clearCookies(_ *cobra.Command, _ []string) error {
+	return runtime.ClearCookies(viper.GetString("cookie-file"))
+}
@@ -8,5 +8,6 @@ require (
 	github.com/spf13/cobra v1.6.1
 	github.com/spf13/viper v1.15.0
 	github.com/stretchr/testify v1.8.1
+	golang.org/x/net v0.4.0
 	gopkg.in/resty.v1 v1.12.0
 )
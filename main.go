@@ -1,10 +1,14 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"intelirest-cli/parser"
 	"intelirest-cli/runtime"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -15,57 +19,292 @@ var rootCmd = &cobra.Command{
 	Short: "RFC-2616 compliant request file runner for CLI's",
 	Args:  cobra.MinimumNArgs(1),
 	RunE:  execute,
+	// Failed requests are no usage errors
+	SilenceUsage: true,
+	// Errors are printed by main, as those of requests may need to be redacted
+	SilenceErrors: true,
 }
 
 func main() {
 	f := rootCmd.Flags()
 	f.StringP("environment", "e", "", "specify environment to run")
-	f.IntP("maxconns", "M", 4, "maximum number of connections for the client")
-	f.BoolP("verbose", "v", false, "enable verbose output")
+	f.IntP("maxconns", "M", 4, "maximum number of requests --parallel runs at once")
+	f.BoolP("parallel", "P", false, "run independent requests concurrently")
+	f.Bool("fail-fast", false, "skip all remaining requests after the first failed request")
+	f.Bool("continue-on-error", false, "run requests even if requests they depend on failed")
+	f.StringSlice("fail-on-status", nil, "status codes failing the run, e.g. 4xx,5xx or 404,500-504")
+	f.Duration("deadline", 0, "maximum duration of the whole run, e.g. 5m")
+	f.Duration("timeout", 0, "maximum duration of a request unless it sets @timeout, e.g. 30s")
+	f.Duration("connect-timeout", 0, "maximum time to establish a connection unless the request sets @connection-timeout")
+	f.Int("retries", 0, "number of times a failed idempotent request is retried unless it sets @retry")
+	f.StringSlice("retry-on", nil, "status codes and error kinds retried, e.g. 502,503,connect (default 429,502-504,connect,connect-timeout)")
+	f.CountP("verbose", "v", "log requests to stderr, -vv adds headers and bodies, -vvv dumps the HTTP traffic")
+	f.String("log-file", "", "write the log to the given file instead of stderr")
+	f.Bool("timings", false, "print the DNS, connect, TLS, first byte and total time of each request to stderr")
+	f.StringP("output", "o", "", "output format: pretty, json, jsonl, junit, tap or http (default pretty on terminals, json otherwise)")
+	f.Bool("stream", false, "write each response as a line of JSON as soon as its request completed, like --output jsonl")
+	f.String("output-file", "", "write the output to the given file instead of stdout")
+	f.Bool("no-color", false, "disable colours of the pretty output")
+	f.Bool("legacy-output", false, "print json and jsonl responses in the format of earlier versions with comma-joined headers")
+	f.Int("max-redirects", runtime.DefaultMaxRedirects, "maximum number of redirects a request follows")
+	f.Bool("persist-cookies", false, "load cookies from and save them to the cookie file")
+	f.Bool("compare", false, "fail if a response differs from the previous response referenced with <>")
+	f.StringSlice("compare-ignore", nil, "JSON paths excluded from response comparison")
+	f.StringArray("redact", nil, "regular expression whose matches are redacted from the output and the log, may be repeated")
+	f.Bool("no-redact", false, "print secrets and sensitive headers in the output and the log, for local debugging")
+
+	pf := rootCmd.PersistentFlags()
+	pf.String("cookie-file", runtime.CookieFileName, "file cookies are persisted to")
 
 	if err := viper.BindPFlags(f); err != nil {
 		panic(err)
 	}
+	if err := viper.BindPFlags(pf); err != nil {
+		panic(err)
+	}
+
+	cookiesListCmd.Flags().Bool("no-redact", false, "print the values of the cookies")
+	cookiesCmd.AddCommand(cookiesListCmd, cookiesClearCmd)
+	rootCmd.AddCommand(cookiesCmd)
 
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		os.Exit(runtime.ExitError)
 	}
-	os.Exit(0)
+	os.Exit(runtime.ExitOK)
+}
+
+// exitError makes rest-cli exit with the given code after reporting err
+type exitError struct {
+	code int
+	err  error
 }
 
-func execute(_ *cobra.Command, args []string) error {
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func execute(_ *cobra.Command, args []string) (err error) {
 	envName := viper.GetString("environment")
-	env, err := runtime.ReadEnvironment(envName)
+	env, err := runtime.LoadEnvironment(envName)
 	if err != nil {
 		return err
 	}
 
-	p, err := parser.New(args[0], env)
+	p, err := parser.New(args[0], env.Variables)
 	if err != nil {
-		return err
+		return &exitError{code: runtime.ExitParseError, err: err}
 	}
 
 	requests, err := p.Parse()
+	if err != nil {
+		return &exitError{code: runtime.ExitParseError, err: err}
+	}
+	for _, warning := range p.Warnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	failOn, err := runtime.ParseStatusSet(viper.GetStringSlice("fail-on-status"))
 	if err != nil {
 		return err
 	}
 
+	var redactor *runtime.Redactor
+	if !viper.GetBool("no-redact") {
+		secrets := append(env.Secrets, p.Secrets()...)
+		if redactor, err = runtime.NewRedactor(secrets, viper.GetStringSlice("redact")); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		// The errors of requests carry their URLs, which may hold secrets
+		err = redactor.RedactError(err)
+	}()
+
 	client := runtime.New(viper.GetInt("maxconns"))
-	if viper.GetBool("verbose") {
-		client.SetVerbose()
+	client.SetProgress(os.Stderr)
+	client.SetRedactor(redactor)
+	if len(env.Auth) > 0 {
+		tokens := runtime.NewTokenManager(env.Auth)
+		tokens.SetProgress(os.Stderr)
+		client.SetTokenManager(tokens)
+	}
+	if level := runtime.LogLevel(viper.GetInt("verbose")); level > runtime.LogOff {
+		logOut := os.Stderr
+		if name := viper.GetString("log-file"); name != "" {
+			f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			logOut = f
+		}
+		logger := runtime.NewLogger(logOut, level)
+		logger.SetRedactor(redactor)
+		client.SetLogger(logger)
+	}
+	if viper.GetBool("parallel") {
+		client.SetParallel()
+	}
+	switch {
+	case viper.GetBool("fail-fast") && viper.GetBool("continue-on-error"):
+		return fmt.Errorf("--fail-fast and --continue-on-error can not be combined")
+	case viper.GetBool("fail-fast"):
+		client.SetErrorMode(runtime.ErrorModeFailFast)
+	case viper.GetBool("continue-on-error"):
+		client.SetErrorMode(runtime.ErrorModeContinue)
+	}
+	client.SetMaxRedirects(viper.GetInt("max-redirects"))
+	client.SetTimeout(viper.GetDuration("timeout"))
+	client.SetConnectTimeout(viper.GetDuration("connect-timeout"))
+	retry := runtime.DefaultRetryPolicy
+	retry.Retries = viper.GetInt("retries")
+	if specs := viper.GetStringSlice("retry-on"); len(specs) > 0 {
+		if retry.On, err = runtime.ParseRetryOn(specs); err != nil {
+			return err
+		}
+	}
+	client.SetRetryPolicy(retry)
+	client.SetFailOnStatus(failOn)
+	if viper.GetBool("compare") {
+		client.SetCompare(viper.GetStringSlice("compare-ignore"))
+	}
+
+	cookieFile := viper.GetString("cookie-file")
+	persistCookies := viper.GetBool("persist-cookies")
+	if persistCookies {
+		jar, err := runtime.ReadCookieJar(cookieFile)
+		if err != nil {
+			return err
+		}
+		client.SetCookieJar(jar)
 	}
 
-	responses, err := client.Do(requests)
+	out, terminal := os.Stdout, isTerminal(os.Stdout)
+	if name := viper.GetString("output-file"); name != "" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out, terminal = f, false
+	}
+	format := viper.GetString("output")
+	if viper.GetBool("stream") {
+		if format != "" && format != runtime.OutputJSONL {
+			return fmt.Errorf("--stream can not be combined with --output %s", format)
+		}
+		format = runtime.OutputJSONL
+	}
+	if format == "" {
+		format = runtime.OutputJSON
+		if terminal {
+			format = runtime.OutputPretty
+		}
+	}
+	reporter, err := runtime.NewReporter(format, out, runtime.ReportOptions{
+		Name:   args[0],
+		Color:  terminal && !viper.GetBool("no-color") && os.Getenv("NO_COLOR") == "",
+		Legacy: viper.GetBool("legacy-output"),
+		FailOn: failOn,
+	})
 	if err != nil {
 		return err
 	}
+	reporter = runtime.RedactingReporter(reporter, redactor)
+	var reportErr error
+	report := func(i int, resp runtime.Response) {
+		if err := reporter.Report(i, resp); err != nil && reportErr == nil {
+			reportErr = err
+		}
+	}
 
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("", "  ")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if deadline := viper.GetDuration("deadline"); deadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, deadline)
+		defer cancelDeadline()
+	}
+	stopSignals := cancelOnSignal(cancel)
+	defer stopSignals()
 
-	if err := enc.Encode(responses); err != nil {
+	var responses []runtime.Response
+	var runErr error
+	if viper.GetBool("stream") {
+		responses = make([]runtime.Response, len(requests))
+		for result := range client.Stream(ctx, requests) {
+			if result.Err != nil {
+				runErr = result.Err
+				continue
+			}
+			responses[result.Index] = result.Response
+			report(result.Index, result.Response)
+		}
+	} else {
+		client.SetResponseCallback(report)
+		responses, runErr = client.Do(ctx, requests)
+	}
+	if persistCookies {
+		if err := client.CookieJar().Save(cookieFile); err != nil {
+			return err
+		}
+	}
+
+	if reportErr != nil {
+		return reportErr
+	}
+	if err := reporter.Finish(responses); err != nil {
 		return err
 	}
+	if viper.GetBool("timings") {
+		if err := printTimings(os.Stderr, responses); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return &exitError{code: runtime.ExitInterrupted, err: errors.New("run interrupted")}
+	}
+
+	code := runtime.ExitCode(responses, failOn)
+	if code == runtime.ExitOK {
+		return nil
+	}
+	if runErr == nil {
+		runErr = errors.New("a response status code matches --fail-on-status")
+	}
+	return &exitError{code: code, err: runErr}
+}
+
+// isTerminal reports whether f is a character device like a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// cancelOnSignal calls cancel on the first SIGINT or SIGTERM so the running requests are stopped
+// and the completed ones reported. A second signal exits immediately.
+func cancelOnSignal(cancel context.CancelFunc) func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "interrupted: cancelling running requests, interrupt again to exit immediately")
+		cancel()
+		if _, ok := <-signals; ok {
+			os.Exit(runtime.ExitInterrupted)
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
 }
@@ -1,8 +1,18 @@
-//go:generate stringer -type Operation -type Option
+//go:generate stringer -type Operation
+//go:generate stringer -type OptionKind
 
 package parser
 
-import "net/url"
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Operation int
 
@@ -15,23 +25,201 @@ const (
 	OperationHEAD
 )
 
-type Option int
+// Method returns the HTTP method of the operation
+func (o Operation) Method() string {
+	return strings.TrimPrefix(o.String(), "Operation")
+}
+
+// OptionKind identifies a request directive given as `# @directive args` comment
+type OptionKind int
 
 const (
-	OptionDoNotFollowRedirect Option = iota
+	OptionDoNotFollowRedirect OptionKind = iota
+	OptionNoLog
+	OptionNoCookieJar
+	OptionNoAutoEncoding
+	OptionTimeout
+	OptionConnectionTimeout
+	OptionName
+	OptionDependsOn
+	OptionRetry
+	OptionPollUntil
+	OptionAuthProvider
 )
 
+// Directives maps the names used in request files to their OptionKind
+var Directives = map[string]OptionKind{
+	"no-redirect":        OptionDoNotFollowRedirect,
+	"no-log":             OptionNoLog,
+	"no-cookie-jar":      OptionNoCookieJar,
+	"no-auto-encoding":   OptionNoAutoEncoding,
+	"timeout":            OptionTimeout,
+	"connection-timeout": OptionConnectionTimeout,
+	"name":               OptionName,
+	"depends-on":         OptionDependsOn,
+	"retry":              OptionRetry,
+	"poll-until":         OptionPollUntil,
+	"auth-provider":      OptionAuthProvider,
+}
+
+// Option is a directive of a request together with its arguments
+type Option struct {
+	Kind OptionKind
+	Args []string `json:",omitempty"`
+}
+
+// Duration interprets the arguments of the option as duration in the IntelliJ form of
+// a number followed by an optional unit of ms, s or m e.g. `10 s` or `500ms`. Seconds are the default unit.
+func (o Option) Duration() (time.Duration, error) {
+	value := strings.Join(o.Args, "")
+	if value == "" {
+		return 0, errors.New("missing duration argument")
+	}
+
+	d, err := parseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse \"%s\" as duration", strings.Join(o.Args, " "))
+	}
+	return d, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	orig := value
+
+	unit := time.Second
+	switch {
+	case strings.HasSuffix(value, "ms"):
+		unit = time.Millisecond
+		value = strings.TrimSuffix(value, "ms")
+	case strings.HasSuffix(value, "s"):
+		value = strings.TrimSuffix(value, "s")
+	case strings.HasSuffix(value, "m"):
+		unit = time.Minute
+		value = strings.TrimSuffix(value, "m")
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("could not parse \"%s\" as duration", orig)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// Retry is the retry policy of a request given as `# @retry 5 backoff=exponential delay=1s max=30s`.
+// Only the number of retries is required, zero values keep the defaults of the client.
+type Retry struct {
+	Retries int
+	Backoff string        `json:",omitempty"`
+	Delay   time.Duration `json:",omitempty"`
+	Max     time.Duration `json:",omitempty"`
+}
+
+// Retry interprets the arguments of the option as retry policy
+func (o Option) Retry() (Retry, error) {
+	var r Retry
+	if len(o.Args) == 0 {
+		return r, errors.New("missing number of retries")
+	}
+
+	n, err := strconv.Atoi(o.Args[0])
+	if err != nil || n < 0 {
+		return r, fmt.Errorf("could not parse \"%s\" as number of retries", o.Args[0])
+	}
+	r.Retries = n
+
+	for _, arg := range o.Args[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return r, fmt.Errorf("expected key=value instead of \"%s\"", arg)
+		}
+
+		switch kv[0] {
+		case "backoff":
+			switch kv[1] {
+			case "constant", "linear", "exponential":
+				r.Backoff = kv[1]
+			default:
+				return r, fmt.Errorf("unknown backoff \"%s\", expected constant, linear or exponential", kv[1])
+			}
+		case "delay":
+			r.Delay, err = parseDuration(kv[1])
+		case "max":
+			r.Max, err = parseDuration(kv[1])
+		default:
+			return r, fmt.Errorf("unknown argument \"%s\"", kv[0])
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// PollUntil is the condition of a request repeated until it holds, given as
+// `# @poll-until $.state == "done" interval=2s timeout=2m`. The subject is either status for the
+// status code or a JSONPath into the response body. Zero durations keep the defaults of the client.
+type PollUntil struct {
+	Subject  string
+	Operator string
+	Value    string
+	Interval time.Duration `json:",omitempty"`
+	Timeout  time.Duration `json:",omitempty"`
+}
+
+// PollUntil interprets the arguments of the option as polling condition
+func (o Option) PollUntil() (PollUntil, error) {
+	var p PollUntil
+	condition := make([]string, 0, len(o.Args))
+	for _, arg := range o.Args {
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "interval="):
+			p.Interval, err = parseDuration(strings.TrimPrefix(arg, "interval="))
+		case strings.HasPrefix(arg, "timeout="):
+			p.Timeout, err = parseDuration(strings.TrimPrefix(arg, "timeout="))
+		default:
+			condition = append(condition, arg)
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+
+	if len(condition) < 3 {
+		return p, errors.New("expected a condition like status == 200 or $.state == \"done\"")
+	}
+	p.Subject, p.Operator, p.Value = condition[0], condition[1], strings.Join(condition[2:], " ")
+	if p.Subject != "status" && !strings.HasPrefix(p.Subject, "$") {
+		return p, fmt.Errorf("unknown subject \"%s\", expected status or a JSONPath starting with $", p.Subject)
+	}
+	if p.Operator != "==" && p.Operator != "!=" {
+		return p, fmt.Errorf("unknown operator \"%s\", expected == or !=", p.Operator)
+	}
+
+	return p, nil
+}
+
 type Request struct {
-	Name      string
-	Operation Operation
-	RawURL    string
-	URL       url.URL
-	Headers   map[string]string
-	Body      string
-	FileLoad  string
-	Parts     []RequestPart
-	Options   []Option
-	Comments  []string
+	Name                string
+	Operation           Operation
+	RawURL              string
+	URL                 url.URL
+	Headers             map[string]string
+	Body                string
+	FileLoad            string
+	Parts               []RequestPart
+	Options             []Option
+	Comments            []string
+	ResponseHandler     string          `json:",omitempty"`
+	ResponseHandlerFile string          `json:",omitempty"`
+	Output              *OutputRedirect `json:",omitempty"`
+	ResponseReferences  []string        `json:",omitempty"`
+	// DependsOn holds the indices of the requests named by the depends-on directives
+	DependsOn []int `json:",omitempty"`
+	// Dir is the directory of the file the request was parsed from, if any
+	Dir string `json:",omitempty"`
 }
 
 func NewRequest(name string) *Request {
@@ -43,13 +231,94 @@ func NewRequest(name string) *Request {
 	}
 }
 
+// Path resolves a relative file name used by the request, like that of its response handler, against the
+// directory of the file the request was parsed from, so it does not depend on the working directory
+func (req *Request) Path(name string) string {
+	if name == "" || req.Dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(req.Dir, name)
+}
+
 func (req *Request) IsMultiPart() bool {
 	return len(req.Parts) > 0
 }
 
+func (req *Request) HasOption(kind OptionKind) bool {
+	_, ok := req.Option(kind)
+	return ok
+}
+
+// Dependencies returns the names of the requests given in the depends-on directives of the request
+func (req *Request) Dependencies() []string {
+	names := make([]string, 0)
+	for _, opt := range req.Options {
+		if opt.Kind != OptionDependsOn {
+			continue
+		}
+		for _, name := range strings.Split(strings.Join(opt.Args, " "), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Variables returns the names of the variables the environment did not resolve in the request.
+// Dynamic variables starting with $ are not included.
+func (req *Request) Variables() []string {
+	texts := []string{req.RawURL, req.Body, req.FileLoad}
+	for _, value := range req.Headers {
+		texts = append(texts, value)
+	}
+	for _, part := range req.Parts {
+		texts = append(texts, part.Body, part.FileLoad)
+		for _, value := range part.Headers {
+			texts = append(texts, value)
+		}
+	}
+	if req.Output != nil {
+		texts = append(texts, req.Output.Path)
+	}
+
+	seen := make(map[string]bool)
+	variables := make([]string, 0)
+	for _, text := range texts {
+		if !strings.Contains(text, "{{") {
+			continue
+		}
+		for _, tok := range ParseMacrosFromLine(text) {
+			if tok.IsMacro && !strings.HasPrefix(tok.Token, "$") && !seen[tok.Token] {
+				seen[tok.Token] = true
+				variables = append(variables, tok.Token)
+			}
+		}
+	}
+	sort.Strings(variables)
+	return variables
+}
+
+// Option returns the last directive of the given kind set on the request
+func (req *Request) Option(kind OptionKind) (Option, bool) {
+	for i := len(req.Options) - 1; i >= 0; i-- {
+		if req.Options[i].Kind == kind {
+			return req.Options[i], true
+		}
+	}
+	return Option{}, false
+}
+
 type RequestPart struct {
 	Name     string
 	Headers  map[string]string
 	Body     string
 	FileLoad string
 }
+
+// OutputRedirect saves the response body to Path. Unless Overwrite is set an existing file is kept
+// and the body saved next to it under a unique name.
+type OutputRedirect struct {
+	Path      string
+	Overwrite bool
+}
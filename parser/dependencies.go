@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resolveDependencies sets DependsOn of every request from the names given in its depends-on
+// directives and makes sure the requests do not depend on each other in a cycle.
+func resolveDependencies(requests []Request) error {
+	indices := make(map[string][]int)
+	for i, req := range requests {
+		indices[req.Name] = append(indices[req.Name], i)
+	}
+
+	for i := range requests {
+		for _, name := range requests[i].Dependencies() {
+			switch idx := indices[name]; len(idx) {
+			case 0:
+				return fmt.Errorf("request \"%s\" depends on unknown request \"%s\"", requests[i].Name, name)
+			case 1:
+				requests[i].DependsOn = append(requests[i].DependsOn, idx[0])
+			default:
+				return fmt.Errorf("request \"%s\" depends on \"%s\" which names %d requests", requests[i].Name, name, len(idx))
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(requests))
+	path := make([]int, 0)
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			// The cycle starts where i was entered the first time
+			names := make([]string, 0)
+			for j := len(path) - 1; j >= 0; j-- {
+				names = append([]string{requests[path[j]].Name}, names...)
+				if path[j] == i {
+					break
+				}
+			}
+			names = append(names, requests[i].Name)
+			return fmt.Errorf("requests depend on each other in a cycle: %s", strings.Join(names, " -> "))
+		}
+
+		state[i] = visiting
+		path = append(path, i)
+		for _, dep := range requests[i].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range requests {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
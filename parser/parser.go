@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -15,6 +16,8 @@ type Parser struct {
 	file        *os.File
 	reader      io.Reader
 	environment map[string]string
+	warnings    []string
+	secrets     []string
 }
 
 func New(name string, env map[string]string) (*Parser, error) {
@@ -39,6 +42,37 @@ func (p *Parser) Close() error {
 	return p.file.Close()
 }
 
+// Warnings returns the problems found by Parse which did not prevent the file from being parsed
+func (p *Parser) Warnings() []string {
+	return p.warnings
+}
+
+// Secrets returns the values of the process environment variables the requests use with {{$processEnv NAME}}
+func (p *Parser) Secrets() []string {
+	return p.secrets
+}
+
+// collectSecrets records the values of the process environment variables used in a line
+func (p *Parser) collectSecrets(text string) {
+	for _, tok := range ParseMacrosFromLine(text) {
+		if !tok.IsMacro {
+			continue
+		}
+		if value, ok := processEnv(tok.Token); ok && value != "" {
+			p.secrets = append(p.secrets, value)
+		}
+	}
+}
+
+// processEnv resolves a {{$processEnv NAME}} macro to the value of the process environment variable NAME
+func processEnv(macro string) (string, bool) {
+	fields := strings.Fields(macro)
+	if len(fields) != 2 || fields[0] != "$processEnv" {
+		return "", false
+	}
+	return os.LookupEnv(fields[1])
+}
+
 func ParseFile(name string, env map[string]string) ([]Request, error) {
 	p, err := New(name, env)
 	if err != nil {
@@ -54,6 +88,8 @@ func (p *Parser) Parse() ([]Request, error) {
 		ParserStateURL ParserState = iota
 		ParserStateHeader
 		ParserStateBody
+		ParserStateHandler
+		ParserStateResponse
 	)
 	state := ParserStateURL
 	scanner := bufio.NewScanner(p.reader)
@@ -64,10 +100,21 @@ func (p *Parser) Parse() ([]Request, error) {
 	partIdx := 0
 	for scanner.Scan() {
 		text := scanner.Text()
+		p.collectSecrets(text)
 		// Split text by unicode.IsSpace
 		tokens := strings.Fields(text)
 		// Used to make error more informative
 		lineIter++
+		if state == ParserStateHandler {
+			// Collect the response handler script up to the closing %}
+			if idx := strings.Index(text, "%}"); idx != -1 {
+				req.ResponseHandler = strings.TrimSpace(req.ResponseHandler + text[:idx])
+				state = ParserStateResponse
+			} else {
+				req.ResponseHandler += text + "\n"
+			}
+			continue
+		}
 		if len(tokens) == 0 {
 			if state == ParserStateHeader {
 				state = ParserStateBody
@@ -92,22 +139,24 @@ func (p *Parser) Parse() ([]Request, error) {
 			state = ParserStateURL
 			// Initialise new Request with the part after the ### as Name of the Request
 			req = NewRequest(strings.Join(tokens[1:], " "))
+			if p.file != nil {
+				req.Dir = filepath.Dir(p.file.Name())
+			}
 			continue
-		case tokens[0] == "#":
+		case (tokens[0] == "#" || tokens[0] == "//") && len(tokens) > 1 && strings.HasPrefix(tokens[1], "@"):
 			if req == nil {
 				return nil, requestNotInitializedError(lineIter)
 			}
-			// Parse comment or add request option
-			if strings.HasPrefix(tokens[1], "@") {
-				// Option
-				switch tokens[1] {
-				case "@no-redirect":
-					req.Options = append(req.Options, OptionDoNotFollowRedirect)
-				}
-			} else {
-				// Comment
-				req.Comments = append(req.Comments, strings.TrimPrefix(text, "#"))
+			// Request directive
+			if err := p.parseDirective(req, tokens[1:], lineIter); err != nil {
+				return nil, err
+			}
+			continue
+		case tokens[0] == "#":
+			if req == nil {
+				return nil, requestNotInitializedError(lineIter)
 			}
+			req.Comments = append(req.Comments, strings.TrimPrefix(text, "#"))
 			continue
 		case strings.HasPrefix(tokens[0], "#"):
 			if req == nil {
@@ -126,6 +175,23 @@ func (p *Parser) Parse() ([]Request, error) {
 			return nil, requestNotInitializedError(lineIter)
 		}
 
+		if state != ParserStateURL && strings.HasPrefix(tokens[0], "<>") {
+			// Reference to a previous response of this request
+			ref := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), "<>"))
+			req.ResponseReferences = append(req.ResponseReferences, macroReplace(p.environment, ref))
+			state = ParserStateResponse
+			continue
+		}
+
+		if state != ParserStateURL && strings.HasPrefix(tokens[0], ">") {
+			// Response handlers and redirections end the request body
+			state = ParserStateResponse
+			if p.parseResponseLine(req, strings.TrimSpace(text)) {
+				state = ParserStateHandler
+			}
+			continue
+		}
+
 		switch state {
 		case ParserStateURL:
 			switch tokens[0] {
@@ -154,7 +220,7 @@ func (p *Parser) Parse() ([]Request, error) {
 			continue
 		case ParserStateHeader:
 			hdrName := strings.TrimSuffix(tokens[0], ":")
-			hdr := strings.Join(tokens[1:], " ")
+			hdr := macroReplace(p.environment, strings.Join(tokens[1:], " "))
 			if partIdx > 0 {
 				if idx := strings.Index(hdr, "name="); idx != -1 {
 					p := hdr[idx+5:]
@@ -190,6 +256,8 @@ func (p *Parser) Parse() ([]Request, error) {
 			} else {
 				req.Body += text
 			}
+		case ParserStateResponse:
+			p.warnings = append(p.warnings, fmt.Sprintf("line %d: ignoring unexpected text after the response handler", lineIter))
 		}
 	}
 
@@ -206,9 +274,95 @@ func (p *Parser) Parse() ([]Request, error) {
 		return nil, fmt.Errorf("scanning error on line %d: %e", lineIter, err)
 	}
 
+	if err := resolveDependencies(requests); err != nil {
+		return nil, err
+	}
+	provider := -1
+	for i, req := range requests {
+		if req.HasOption(OptionAuthProvider) {
+			if provider >= 0 {
+				return nil, fmt.Errorf("requests \"%s\" and \"%s\" are both marked as @auth-provider", requests[provider].Name, req.Name)
+			}
+			provider = i
+		}
+	}
+
 	return requests, nil
 }
 
+func (p *Parser) parseDirective(req *Request, tokens []string, line int) error {
+	name := strings.TrimPrefix(tokens[0], "@")
+	kind, ok := Directives[name]
+	if !ok {
+		p.warnings = append(p.warnings, fmt.Sprintf("line %d: ignoring unknown directive @%s", line, name))
+		return nil
+	}
+
+	opt := Option{Kind: kind}
+	if len(tokens) > 1 {
+		opt.Args = tokens[1:]
+	}
+
+	switch kind {
+	case OptionTimeout, OptionConnectionTimeout:
+		if _, err := opt.Duration(); err != nil {
+			return fmt.Errorf("error on line %d: directive @%s: %w", line, name, err)
+		}
+	case OptionRetry:
+		if _, err := opt.Retry(); err != nil {
+			return fmt.Errorf("error on line %d: directive @%s: %w", line, name, err)
+		}
+	case OptionPollUntil:
+		if _, err := opt.PollUntil(); err != nil {
+			return fmt.Errorf("error on line %d: directive @%s: %w", line, name, err)
+		}
+	case OptionName:
+		if len(opt.Args) == 0 {
+			return fmt.Errorf("error on line %d: directive @%s requires a name", line, name)
+		}
+		req.Name = strings.Join(opt.Args, " ")
+	case OptionDependsOn:
+		if len(opt.Args) == 0 {
+			return fmt.Errorf("error on line %d: directive @%s requires the names of requests", line, name)
+		}
+	}
+
+	req.Options = append(req.Options, opt)
+	return nil
+}
+
+// parseResponseLine parses a line starting with > which either saves the response body with
+// `>> path` or `>>! path` or gives a response handler as `> {% script %}` or `> path`.
+// It reports whether the line opens a script which continues on the following lines.
+func (p *Parser) parseResponseLine(req *Request, text string) bool {
+	switch {
+	case strings.HasPrefix(text, ">>!"):
+		req.Output = &OutputRedirect{
+			Path:      macroReplace(p.environment, strings.TrimSpace(strings.TrimPrefix(text, ">>!"))),
+			Overwrite: true,
+		}
+	case strings.HasPrefix(text, ">>"):
+		req.Output = &OutputRedirect{
+			Path: macroReplace(p.environment, strings.TrimSpace(strings.TrimPrefix(text, ">>"))),
+		}
+	default:
+		handler := strings.TrimSpace(strings.TrimPrefix(text, ">"))
+		if !strings.HasPrefix(handler, "{%") {
+			req.ResponseHandlerFile = handler
+			return false
+		}
+
+		handler = strings.TrimPrefix(handler, "{%")
+		if idx := strings.Index(handler, "%}"); idx != -1 {
+			req.ResponseHandler = strings.TrimSpace(handler[:idx])
+			return false
+		}
+		req.ResponseHandler = handler + "\n"
+		return true
+	}
+	return false
+}
+
 func requestNotInitializedError(line int) error {
 	return fmt.Errorf("error in line %d: request is not initialised did you forget the ### $NAME line at the beginning", line)
 }
@@ -290,6 +444,11 @@ func macroReplace(vars map[string]string, text string) string {
 	for _, tok := range tokens {
 		result := tok.Token
 		if tok.IsMacro {
+			// Keep unknown variables so they can be resolved when the request is executed
+			result = "{{" + tok.Token + "}}"
+			if value, ok := processEnv(tok.Token); ok {
+				result = value
+			}
 			for key, value := range vars {
 				if key == tok.Token {
 					result = value
@@ -323,7 +482,7 @@ func ParseMacrosFromLine(s string) []token {
 	macroStartIndex := 0
 	macroEndIndex := 0
 	for i, r := range s {
-		if r == '{' {
+		if r == '{' && startElementCounter < 2 {
 			startElementCounter++
 			if startElementCounter == 2 {
 				macroStartIndex = i + 1
@@ -335,7 +494,7 @@ func ParseMacrosFromLine(s string) []token {
 					}
 				}
 			}
-		} else if r == '}' {
+		} else if r == '}' && startElementCounter == 2 {
 			endElementCounter++
 			if endElementCounter == 2 {
 				macroEndIndex = i - 1
@@ -343,6 +502,11 @@ func ParseMacrosFromLine(s string) []token {
 				endElementCounter = 0
 				startElementCounter = 0
 			}
+		} else if startElementCounter < 2 {
+			// Braces only start a macro when they directly follow each other
+			startElementCounter = 0
+		} else {
+			endElementCounter = 0
 		}
 	}
 
@@ -352,7 +516,7 @@ func ParseMacrosFromLine(s string) []token {
 			start:   0,
 			end:     len(s),
 		})
-	} else {
+	} else if macroEndIndex+2 < len(s) {
 		spans = append(spans, span{
 			isMacro: false,
 			start:   macroEndIndex + 2,
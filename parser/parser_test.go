@@ -3,6 +3,7 @@ package parser
 import (
 	"bytes"
 	"net/url"
+	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -37,6 +38,14 @@ func TestParseMacrosFromLine(t *testing.T) {
 				{Token: "$timestamp", IsMacro: true},
 			},
 		},
+		{
+			input: `{"user":{"id":"{{id}}"}}`,
+			tokens: []token{
+				{Token: `{"user":{"id":"`, IsMacro: false},
+				{Token: "id", IsMacro: true},
+				{Token: `"}}`, IsMacro: false},
+			},
+		},
 	}
 	for _, c := range tc {
 		tok := ParseMacrosFromLine(c.input)
@@ -147,7 +156,7 @@ GET http://httpbin.org/status/301
 					},
 					Headers: make(map[string]string),
 					Options: []Option{
-						OptionDoNotFollowRedirect,
+						{Kind: OptionDoNotFollowRedirect},
 					},
 					Comments: make([]string, 0),
 				},
@@ -357,3 +366,294 @@ Content-Type: application/json
 		assert.Equal(t, c.output, requests, "Test %d failed", i)
 	}
 }
+
+func TestDirectives(t *testing.T) {
+	input := `### Login
+# @name login
+# @no-log
+// @no-cookie-jar
+# @no-auto-encoding
+# @timeout 10 s
+# @connection-timeout 500ms
+# @unknown-directive
+GET https://httpbin.org/get
+`
+	p, err := NewReader(bytes.NewBufferString(input), nil)
+	assert.NoError(t, err)
+	requests, err := p.Parse()
+	assert.NoError(t, err)
+	assert.Len(t, requests, 1)
+
+	req := requests[0]
+	assert.Equal(t, "login", req.Name)
+	assert.Equal(t, []Option{
+		{Kind: OptionName, Args: []string{"login"}},
+		{Kind: OptionNoLog},
+		{Kind: OptionNoCookieJar},
+		{Kind: OptionNoAutoEncoding},
+		{Kind: OptionTimeout, Args: []string{"10", "s"}},
+		{Kind: OptionConnectionTimeout, Args: []string{"500ms"}},
+	}, req.Options)
+
+	opt, ok := req.Option(OptionTimeout)
+	assert.True(t, ok)
+	timeout, err := opt.Duration()
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, timeout)
+
+	opt, ok = req.Option(OptionConnectionTimeout)
+	assert.True(t, ok)
+	timeout, err = opt.Duration()
+	assert.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, timeout)
+
+	assert.Len(t, p.Warnings(), 1)
+
+	p, err = NewReader(bytes.NewBufferString("### Broken\n# @timeout soon\nGET https://httpbin.org/get\n"), nil)
+	assert.NoError(t, err)
+	_, err = p.Parse()
+	assert.Error(t, err)
+}
+
+func TestRetryDirective(t *testing.T) {
+	retry, err := Option{Kind: OptionRetry, Args: []string{"5", "backoff=exponential", "max=30s"}}.Retry()
+	assert.NoError(t, err)
+	assert.Equal(t, Retry{Retries: 5, Backoff: "exponential", Max: 30 * time.Second}, retry)
+
+	retry, err = Option{Kind: OptionRetry, Args: []string{"2", "delay=500ms"}}.Retry()
+	assert.NoError(t, err)
+	assert.Equal(t, Retry{Retries: 2, Delay: 500 * time.Millisecond}, retry)
+
+	for _, args := range [][]string{{}, {"many"}, {"3", "backoff=random"}, {"3", "max"}, {"3", "jitter=1s"}, {"3", "max=soon"}} {
+		_, err = Option{Kind: OptionRetry, Args: args}.Retry()
+		assert.Error(t, err, "%v", args)
+	}
+
+	p, err := NewReader(bytes.NewBufferString("### Broken\n# @retry 3 backoff=random\nGET https://httpbin.org/get\n"), nil)
+	assert.NoError(t, err)
+	_, err = p.Parse()
+	assert.Error(t, err)
+}
+
+func TestPollUntilDirective(t *testing.T) {
+	poll, err := Option{Kind: OptionPollUntil, Args: []string{"$.state", "==", `"in`, `progress"`, "interval=2s", "timeout=2m"}}.PollUntil()
+	assert.NoError(t, err)
+	assert.Equal(t, PollUntil{Subject: "$.state", Operator: "==", Value: `"in progress"`, Interval: 2 * time.Second, Timeout: 2 * time.Minute}, poll)
+
+	poll, err = Option{Kind: OptionPollUntil, Args: []string{"status", "!=", "202"}}.PollUntil()
+	assert.NoError(t, err)
+	assert.Equal(t, PollUntil{Subject: "status", Operator: "!=", Value: "202"}, poll)
+
+	for _, args := range [][]string{{}, {"status", "=="}, {"state", "==", "done"}, {"status", "<", "300"}, {"status", "==", "200", "interval=soon"}} {
+		_, err = Option{Kind: OptionPollUntil, Args: args}.PollUntil()
+		assert.Error(t, err, "%v", args)
+	}
+}
+
+func TestResponseLines(t *testing.T) {
+	input := `### Save user
+POST https://httpbin.org/post
+Content-Type: application/json
+
+{
+  "id": 999
+}
+
+> {%
+client.test("Request executed successfully", function() {
+  client.assert(response.status === 200, "Response status is not 200");
+});
+%}
+
+>> ./out/{{name}}.json
+
+### Overwrite user
+GET https://httpbin.org/get
+
+> ./handler.js
+>>! ./out/user.json
+
+<> ./out/{{name}}-2.json
+<> ./out/{{name}}-1.json
+`
+	p, err := NewReader(bytes.NewBufferString(input), map[string]string{"name": "user"})
+	assert.NoError(t, err)
+	requests, err := p.Parse()
+	assert.NoError(t, err)
+	assert.Empty(t, p.Warnings())
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, `{"id":999}`, requests[0].Body)
+		assert.Equal(t, `client.test("Request executed successfully", function() {
+  client.assert(response.status === 200, "Response status is not 200");
+});`, requests[0].ResponseHandler)
+		assert.Equal(t, &OutputRedirect{Path: "./out/user.json"}, requests[0].Output)
+
+		assert.Empty(t, requests[1].Body)
+		assert.Equal(t, "./handler.js", requests[1].ResponseHandlerFile)
+		assert.Equal(t, &OutputRedirect{Path: "./out/user.json", Overwrite: true}, requests[1].Output)
+		assert.Equal(t, []string{"./out/user-2.json", "./out/user-1.json"}, requests[1].ResponseReferences)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	input := `### Unresolved variables
+POST {{host}}/users/{{id}}?ts={{$timestamp}}
+Authorization: Bearer {{token}}
+Content-Type: application/json
+
+{
+  "id": {{id}},
+  "name": {{name}}
+}
+`
+	p, err := NewReader(bytes.NewBufferString(input), map[string]string{"host": "https://httpbin.org", "name": "test"})
+	assert.NoError(t, err)
+	requests, err := p.Parse()
+	assert.NoError(t, err)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, "https://httpbin.org/users/{{id}}?ts={{$timestamp}}", requests[0].RawURL)
+		assert.Equal(t, `{"id":"{{id}}","name":"test"}`, requests[0].Body)
+		assert.Equal(t, []string{"id", "token"}, requests[0].Variables())
+	}
+
+	// Unknown variables are kept literally in output paths and headers too, and so are undefined process
+	// environment variables
+	input = `### Output
+GET {{host}}/users/{{id}}
+X-Trace: {{name}}-{{$processEnv INTELIREST_UNDEFINED}}
+
+>> ./out/{{name}}-{{id}}.json
+`
+	p, err = NewReader(bytes.NewBufferString(input), map[string]string{"host": "https://httpbin.org", "name": "test"})
+	assert.NoError(t, err)
+	requests, err = p.Parse()
+	assert.NoError(t, err)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, "test-{{$processEnv INTELIREST_UNDEFINED}}", requests[0].Headers["X-Trace"])
+		assert.Equal(t, "./out/test-{{id}}.json", requests[0].Output.Path)
+		assert.Equal(t, []string{"id"}, requests[0].Variables())
+	}
+}
+
+func TestProcessEnv(t *testing.T) {
+	assert.NoError(t, os.Setenv("REST_CLI_TEST_TOKEN", "s3cr3t"))
+	defer os.Unsetenv("REST_CLI_TEST_TOKEN")
+
+	input := `### Process environment
+GET {{host}}/me
+Authorization: Bearer {{$processEnv REST_CLI_TEST_TOKEN}}
+X-Missing: {{$processEnv REST_CLI_TEST_MISSING}}
+X-Host: {{host}}
+`
+	p, err := NewReader(bytes.NewBufferString(input), map[string]string{"host": "https://httpbin.org"})
+	assert.NoError(t, err)
+	requests, err := p.Parse()
+	assert.NoError(t, err)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, "Bearer s3cr3t", requests[0].Headers["Authorization"])
+		assert.Equal(t, "{{$processEnv REST_CLI_TEST_MISSING}}", requests[0].Headers["X-Missing"])
+		assert.Equal(t, "https://httpbin.org", requests[0].Headers["X-Host"])
+	}
+	assert.Equal(t, []string{"s3cr3t"}, p.Secrets())
+}
+
+func TestHeaderVariables(t *testing.T) {
+	input := `### Headers
+GET https://httpbin.org/headers
+Authorization: Basic {{user}} {{password}}
+X-Request-Id: req-{{id}}
+X-Unknown: {{unknown}}
+`
+	p, err := NewReader(bytes.NewBufferString(input), map[string]string{"user": "me", "password": "passwd", "id": "42"})
+	assert.NoError(t, err)
+	requests, err := p.Parse()
+	assert.NoError(t, err)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, "Basic me passwd", requests[0].Headers["Authorization"])
+		assert.Equal(t, "req-42", requests[0].Headers["X-Request-Id"])
+		// Variables the environment does not define are resolved when the request is executed
+		assert.Equal(t, "{{unknown}}", requests[0].Headers["X-Unknown"])
+	}
+}
+
+func TestAuthProviderDirective(t *testing.T) {
+	input := `### Login
+# @auth-provider
+POST https://httpbin.org/post
+
+### Refresh
+# @auth-provider
+POST https://httpbin.org/post
+`
+	p, err := NewReader(bytes.NewBufferString(input), nil)
+	assert.NoError(t, err)
+	_, err = p.Parse()
+	assert.EqualError(t, err, `requests "Login" and "Refresh" are both marked as @auth-provider`)
+}
+
+func TestDependencies(t *testing.T) {
+	input := `### Login
+# @name login
+POST https://httpbin.org/post
+
+### Create user
+# @name create-user
+# @depends-on login
+POST https://httpbin.org/post
+
+### Get user
+# @depends-on login, create-user
+GET https://httpbin.org/get
+`
+	p, err := NewReader(bytes.NewBufferString(input), nil)
+	assert.NoError(t, err)
+	requests, err := p.Parse()
+	assert.NoError(t, err)
+	if assert.Len(t, requests, 3) {
+		assert.Empty(t, requests[0].DependsOn)
+		assert.Equal(t, []int{0}, requests[1].DependsOn)
+		assert.Equal(t, []string{"login", "create-user"}, requests[2].Dependencies())
+		assert.Equal(t, []int{0, 1}, requests[2].DependsOn)
+	}
+
+	tc := []struct {
+		input string
+		err   string
+	}{
+		{
+			input: `### a
+# @depends-on c
+GET https://httpbin.org/get
+
+### b
+# @depends-on a
+GET https://httpbin.org/get
+
+### c
+# @depends-on b
+GET https://httpbin.org/get
+`,
+			err: "requests depend on each other in a cycle: a -> c -> b -> a",
+		},
+		{
+			input: `### a
+# @depends-on a
+GET https://httpbin.org/get
+`,
+			err: "requests depend on each other in a cycle: a -> a",
+		},
+		{
+			input: `### a
+# @depends-on missing
+GET https://httpbin.org/get
+`,
+			err: "request \"a\" depends on unknown request \"missing\"",
+		},
+	}
+	for _, c := range tc {
+		p, err := NewReader(bytes.NewBufferString(c.input), nil)
+		assert.NoError(t, err)
+		_, err = p.Parse()
+		assert.EqualError(t, err, c.err)
+	}
+}
@@ -0,0 +1,111 @@
+package runtime
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"intelirest-cli/parser"
+)
+
+// requestHeaders returns the headers sent with a request, with IntelliJ's authorization shorthands expanded.
+// The Digest shorthand is answered by the transport, see requestDigestAuth.
+func requestHeaders(req parser.Request) map[string]string {
+	headers := make(map[string]string, len(req.Headers))
+	for key, value := range req.Headers {
+		if http.CanonicalHeaderKey(key) == "Authorization" {
+			if _, ok := digestAuth(value); ok {
+				continue
+			}
+			value = basicAuth(value)
+		}
+		headers[key] = value
+	}
+	return headers
+}
+
+// requestDigestAuth returns the credentials of a request authorized with `Authorization: Digest user password`
+func requestDigestAuth(req parser.Request) (digestCredentials, bool) {
+	for key, value := range req.Headers {
+		if http.CanonicalHeaderKey(key) == "Authorization" {
+			return digestAuth(value)
+		}
+	}
+	return digestCredentials{}, false
+}
+
+// basicAuth encodes the credentials of an authorization given as `Basic user password`. Other values,
+// including already encoded credentials, are returned unchanged.
+func basicAuth(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) != 3 || !strings.EqualFold(fields[0], "Basic") {
+		return value
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(fields[1]+":"+fields[2]))
+}
+
+// reauthenticator re-runs the request marked with the auth-provider directive when requests are rejected
+// with 401 Unauthorized, like after the access token of a long run expired
+type reauthenticator struct {
+	// running serializes the re-runs of the provider, mu guards the fields
+	running  sync.Mutex
+	mu       sync.Mutex
+	provider *parser.Request
+	// generation counts the re-runs of the provider so requests rejected at the same time re-run it once
+	generation int
+}
+
+// current returns the number of re-runs of the provider
+func (a *reauthenticator) current() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.generation
+}
+
+// SetAuthProvider sets the request re-run to authenticate again when a request is rejected with 401 Unauthorized.
+// The global variables set by its response handler are used by the repeated request. Do sets the request
+// with the auth-provider directive as auth provider.
+func (c *Client) SetAuthProvider(req parser.Request) {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+	c.auth.provider = &req
+}
+
+// reauthenticate re-runs the auth provider for a request rejected with 401 Unauthorized which was sent in
+// the given generation of the provider. It reports whether the request should be repeated, which is the
+// case if the provider succeeded now or was re-run by another request since.
+func (c *Client) reauthenticate(ctx context.Context, req parser.Request, generation int) bool {
+	if req.HasOption(parser.OptionAuthProvider) {
+		return false
+	}
+
+	c.auth.running.Lock()
+	defer c.auth.running.Unlock()
+	c.auth.mu.Lock()
+	provider, current := c.auth.provider, c.auth.generation
+	c.auth.mu.Unlock()
+	if provider == nil {
+		return false
+	}
+	if current != generation {
+		return true
+	}
+
+	logger := c.loggerFor(req)
+	logger.Logf(LogRequests, "%s: re-authenticating with \"%s\"", req.Name, provider.Name)
+	resp, err := c.ExecuteRequest(ctx, *provider)
+	if err == nil && resp.ReturnCode >= http.StatusBadRequest {
+		err = errors.New(statusLine(resp.ReturnCode))
+	}
+	if err != nil {
+		logger.Logf(LogRequests, "%s: re-authentication with \"%s\" failed: %s", req.Name, provider.Name, err)
+		return false
+	}
+	c.auth.mu.Lock()
+	c.auth.generation++
+	c.auth.mu.Unlock()
+	return true
+}
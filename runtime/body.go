@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// EncodingBase64 is the Encoding of responses whose binary Content is given base64 encoded
+const EncodingBase64 = "base64"
+
+// setBody stores the body of a response according to its content type. Text bodies are converted
+// to UTF-8 and JSON bodies are embedded into the output as JSON. Bodies which are not text are kept
+// as they are and given base64 encoded in the output. Without content type the type is sniffed.
+func (r *Response) setBody(contentType string, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+
+	if text, ok := decodeText(mediaType, params["charset"], body); ok {
+		r.Body = text
+		if isJSONMediaType(mediaType) && json.Valid(text) {
+			r.Content = json.RawMessage(text)
+		} else {
+			r.Content, _ = json.Marshal(string(text))
+		}
+		return
+	}
+
+	r.Body = body
+	r.Content, _ = json.Marshal(base64.StdEncoding.EncodeToString(body))
+	r.Encoding = EncodingBase64
+}
+
+// decodeText converts a body of a textual media type from its charset to UTF-8. It reports false
+// for bodies of other media types and bodies which are no valid text in their charset.
+func decodeText(mediaType, cs string, body []byte) ([]byte, bool) {
+	if !isTextMediaType(mediaType) {
+		return nil, false
+	}
+
+	switch strings.ToLower(cs) {
+	case "", "utf-8", "utf8", "us-ascii":
+	default:
+		if enc, _ := charset.Lookup(cs); enc != nil {
+			decoded, err := enc.NewDecoder().Bytes(body)
+			if err != nil {
+				return nil, false
+			}
+			body = decoded
+		}
+	}
+
+	return body, utf8.Valid(body)
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func isTextMediaType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") || isJSONMediaType(mediaType) || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/xml", "application/javascript", "application/ecmascript", "application/x-www-form-urlencoded",
+		"application/yaml", "application/x-yaml", "application/graphql", "application/x-ndjson":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package runtime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of a Difference
+const (
+	DifferenceAdded   = "added"
+	DifferenceRemoved = "removed"
+	DifferenceChanged = "changed"
+)
+
+// Difference is a single change of a response body compared to a previous response.
+// Path is a JSON path like $.items[0].id for JSON bodies and the line number for text bodies.
+type Difference struct {
+	Path     string
+	Kind     string
+	Expected interface{} `json:",omitempty"`
+	Actual   interface{} `json:",omitempty"`
+}
+
+// CompareFile compares body to the previous response saved in the file with the given name.
+// Differences at or below one of the ignored JSON paths are not reported.
+func CompareFile(name string, body []byte, ignore []string) ([]Difference, error) {
+	previous, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Compare(previous, body, ignore), nil
+}
+
+// Compare compares two response bodies structurally if both are JSON and line by line otherwise
+func Compare(expected, actual []byte, ignore []string) []Difference {
+	var expectedValue, actualValue interface{}
+	if json.Unmarshal(expected, &expectedValue) == nil && json.Unmarshal(actual, &actualValue) == nil {
+		diffs := make([]Difference, 0)
+		return compareJSON("$", expectedValue, actualValue, ignore, diffs)
+	}
+	return compareText(string(expected), string(actual))
+}
+
+func compareJSON(path string, expected, actual interface{}, ignore []string, diffs []Difference) []Difference {
+	if isIgnored(path, ignore) {
+		return diffs
+	}
+
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(e)+len(a))
+		for key := range e {
+			keys = append(keys, key)
+		}
+		for key := range a {
+			if _, ok := e[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			keyPath := path + "." + key
+			ev, inExpected := e[key]
+			av, inActual := a[key]
+			switch {
+			case !inActual:
+				if !isIgnored(keyPath, ignore) {
+					diffs = append(diffs, Difference{Path: keyPath, Kind: DifferenceRemoved, Expected: ev})
+				}
+			case !inExpected:
+				if !isIgnored(keyPath, ignore) {
+					diffs = append(diffs, Difference{Path: keyPath, Kind: DifferenceAdded, Actual: av})
+				}
+			default:
+				diffs = compareJSON(keyPath, ev, av, ignore, diffs)
+			}
+		}
+		return diffs
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(e) || i < len(a); i++ {
+			itemPath := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(a):
+				if !isIgnored(itemPath, ignore) {
+					diffs = append(diffs, Difference{Path: itemPath, Kind: DifferenceRemoved, Expected: e[i]})
+				}
+			case i >= len(e):
+				if !isIgnored(itemPath, ignore) {
+					diffs = append(diffs, Difference{Path: itemPath, Kind: DifferenceAdded, Actual: a[i]})
+				}
+			default:
+				diffs = compareJSON(itemPath, e[i], a[i], ignore, diffs)
+			}
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		diffs = append(diffs, Difference{Path: path, Kind: DifferenceChanged, Expected: expected, Actual: actual})
+	}
+	return diffs
+}
+
+// isIgnored reports whether path is one of the ignored paths or lies below one of them
+func isIgnored(path string, ignore []string) bool {
+	for _, i := range ignore {
+		if !strings.HasPrefix(i, "$") {
+			i = "$." + i
+		}
+		if path == i || strings.HasPrefix(path, i+".") || strings.HasPrefix(path, i+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTextDiff limits the size of the table compareText finds the longest common subsequence with,
+// about 32 MB
+const maxTextDiff = 1 << 22
+
+// compareText reports the lines removed from and added to expected based on their longest common subsequence.
+// If too many lines changed to compute it, only the first changed line is reported.
+func compareText(expected, actual string) []Difference {
+	e := strings.Split(strings.TrimSuffix(expected, "\n"), "\n")
+	a := strings.Split(strings.TrimSuffix(actual, "\n"), "\n")
+
+	// Equal lines at the start and the end are left out of the table
+	offset := 0
+	for offset < len(e) && offset < len(a) && e[offset] == a[offset] {
+		offset++
+	}
+	e, a = e[offset:], a[offset:]
+	for len(e) > 0 && len(a) > 0 && e[len(e)-1] == a[len(a)-1] {
+		e, a = e[:len(e)-1], a[:len(a)-1]
+	}
+
+	if len(e) > 0 && len(a) > 0 && (len(e)+1)*(len(a)+1) > maxTextDiff {
+		return []Difference{{Path: strconv.Itoa(offset + 1), Kind: DifferenceChanged, Expected: e[0], Actual: a[0]}}
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of e[i:] and a[j:]
+	lcs := make([][]int, len(e)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(a)+1)
+	}
+	for i := len(e) - 1; i >= 0; i-- {
+		for j := len(a) - 1; j >= 0; j-- {
+			if e[i] == a[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diffs := make([]Difference, 0)
+	i, j := 0, 0
+	for i < len(e) || j < len(a) {
+		switch {
+		case i < len(e) && j < len(a) && e[i] == a[j]:
+			i++
+			j++
+		case i < len(e) && (j == len(a) || lcs[i+1][j] >= lcs[i][j+1]):
+			diffs = append(diffs, Difference{Path: strconv.Itoa(offset + i + 1), Kind: DifferenceRemoved, Expected: e[i]})
+			i++
+		default:
+			diffs = append(diffs, Difference{Path: strconv.Itoa(offset + j + 1), Kind: DifferenceAdded, Actual: a[j]})
+			j++
+		}
+	}
+	return diffs
+}
+
+// responseBody returns the body of a response, reading it back from disk if it was saved to a file
+func responseBody(resp *Response) ([]byte, error) {
+	if resp.SavedTo != "" {
+		return ioutil.ReadFile(resp.SavedTo)
+	}
+	return resp.Body, nil
+}
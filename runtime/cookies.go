@@ -0,0 +1,216 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieFileName is the default name of the file cookies are persisted to, the same as IntelliJ uses
+const CookieFileName = "http-client.cookies"
+
+const cookieFileHeader = "# domain\tpath\tname\tvalue\tdate\tsecure\thttponly"
+
+// sessionCookieDate marks cookies without expiry in the cookie file
+const sessionCookieDate = "-1"
+
+// CookieJar is a http.CookieJar which remembers the cookies it stores so they can be listed and
+// persisted between runs. Domain cookies are recorded with a leading dot, host only cookies without.
+type CookieJar struct {
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	entries map[string]*http.Cookie
+}
+
+// NewCookieJar creates an empty CookieJar
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &CookieJar{
+		jar:     jar,
+		entries: make(map[string]*http.Cookie),
+	}
+}
+
+// ReadCookieJar creates a CookieJar holding the cookies of the given cookie file. A missing file yields an empty jar.
+func ReadCookieJar(name string) (*CookieJar, error) {
+	j := NewCookieJar()
+
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineIter := 0
+	for scanner.Scan() {
+		lineIter++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Files of earlier versions have no secure and httponly fields
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 && len(fields) != 7 {
+			return nil, fmt.Errorf("error in cookie file %s on line %d: expected 5 or 7 tab separated fields", name, lineIter)
+		}
+
+		cookie := &http.Cookie{Path: fields[1], Name: fields[2], Value: fields[3]}
+		if len(fields) == 7 {
+			cookie.Secure = fields[5] == "true"
+			cookie.HttpOnly = fields[6] == "true"
+		}
+		if fields[4] != sessionCookieDate {
+			expires, err := http.ParseTime(fields[4])
+			if err != nil {
+				return nil, fmt.Errorf("error in cookie file %s on line %d: %w", name, lineIter, err)
+			}
+			if expires.Before(time.Now()) {
+				continue
+			}
+			cookie.Expires = expires
+		}
+
+		host := fields[0]
+		if strings.HasPrefix(host, ".") {
+			host = strings.TrimPrefix(host, ".")
+			cookie.Domain = host
+		}
+
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		j.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// SetCookies implements the http.CookieJar interface
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		entry := *c
+		if entry.Domain == "" {
+			entry.Domain = strings.ToLower(u.Hostname())
+		} else {
+			entry.Domain = "." + strings.TrimPrefix(strings.ToLower(entry.Domain), ".")
+		}
+		if entry.Path == "" || entry.Path[0] != '/' {
+			entry.Path = defaultCookiePath(u.Path)
+		}
+		if entry.MaxAge > 0 {
+			entry.Expires = now.Add(time.Duration(entry.MaxAge) * time.Second)
+		}
+
+		key := entry.Domain + ";" + entry.Path + ";" + entry.Name
+		if entry.MaxAge < 0 || (!entry.Expires.IsZero() && entry.Expires.Before(now)) {
+			delete(j.entries, key)
+			continue
+		}
+		j.entries[key] = &entry
+	}
+}
+
+// Cookies implements the http.CookieJar interface
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// All returns every unexpired cookie of the jar ordered by domain, path and name
+func (j *CookieJar) All() []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	cookies := make([]*http.Cookie, 0, len(j.entries))
+	for _, c := range j.entries {
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+		cookies = append(cookies, c)
+	}
+
+	sort.Slice(cookies, func(a, b int) bool {
+		if cookies[a].Domain != cookies[b].Domain {
+			return cookies[a].Domain < cookies[b].Domain
+		}
+		if cookies[a].Path != cookies[b].Path {
+			return cookies[a].Path < cookies[b].Path
+		}
+		return cookies[a].Name < cookies[b].Name
+	})
+
+	return cookies
+}
+
+// Save writes all cookies of the jar to the given cookie file. The file is only readable by the user
+// as it holds session cookies.
+func (j *CookieJar) Save(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, cookieFileHeader)
+	for _, c := range j.All() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%t\n", c.Domain, c.Path, c.Name, c.Value, CookieExpiry(c), c.Secure, c.HttpOnly)
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ClearCookies removes the given cookie file
+func ClearCookies(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// CookieExpiry formats the expiry of a cookie the way the cookie file stores it
+func CookieExpiry(c *http.Cookie) string {
+	if c.Expires.IsZero() {
+		return sessionCookieDate
+	}
+	return c.Expires.UTC().Format(http.TimeFormat)
+}
+
+// defaultCookiePath implements the default-path algorithm of RFC 6265 section 5.1.4
+func defaultCookiePath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
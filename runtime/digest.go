@@ -0,0 +1,276 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type digestKey struct{}
+
+// digestCredentials are the user and password a request authenticates with using digest access authentication
+type digestCredentials struct {
+	user     string
+	password string
+}
+
+// digestAuth parses an authorization given as `Digest user password`
+func digestAuth(value string) (digestCredentials, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 || !strings.EqualFold(fields[0], "Digest") {
+		return digestCredentials{}, false
+	}
+	return digestCredentials{user: fields[1], password: fields[2]}, true
+}
+
+// withDigestAuth makes the transport of the client answer digest challenges for the request with creds
+func withDigestAuth(ctx context.Context, creds digestCredentials) context.Context {
+	return context.WithValue(ctx, digestKey{}, creds)
+}
+
+// digestChallenge is a WWW-Authenticate challenge of the Digest scheme as described in RFC 7616
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	userhash  bool
+
+	// count is the number of requests authorized with the nonce
+	count int
+}
+
+// digestAlgorithms are the supported algorithms by preference
+var digestAlgorithms = []string{"SHA-256", "SHA-256-sess", "MD5", "MD5-sess"}
+
+// parseDigestChallenge returns the challenge with the most preferred supported algorithm of a 401 response
+func parseDigestChallenge(header http.Header) (*digestChallenge, bool) {
+	var best *digestChallenge
+	rank := len(digestAlgorithms)
+	for _, value := range header[http.CanonicalHeaderKey("WWW-Authenticate")] {
+		if len(value) < 7 || !strings.EqualFold(value[:7], "Digest ") {
+			continue
+		}
+		params := parseAuthParams(value[7:])
+		challenge := &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: params["algorithm"],
+			userhash:  strings.EqualFold(params["userhash"], "true"),
+		}
+		if challenge.algorithm == "" {
+			challenge.algorithm = "MD5"
+		}
+		if qop, ok := params["qop"]; ok {
+			// Only qop=auth is supported, auth-int would require hashing the body
+			for _, option := range strings.Split(qop, ",") {
+				if strings.TrimSpace(option) == "auth" {
+					challenge.qop = "auth"
+				}
+			}
+			if challenge.qop == "" {
+				continue
+			}
+		}
+		for i, algorithm := range digestAlgorithms {
+			if strings.EqualFold(algorithm, challenge.algorithm) && i < rank && challenge.nonce != "" {
+				challenge.algorithm = algorithm
+				best, rank = challenge, i
+			}
+		}
+	}
+	return best, best != nil
+}
+
+// parseAuthParams parses the comma separated key=value pairs of a challenge, with optionally quoted values
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:end]))
+			s = s[end:]
+		}
+		params[key] = value.String()
+	}
+}
+
+// authorization computes the Authorization header answering the challenge for a request
+func (c *digestChallenge) authorization(creds digestCredentials, method, uri string) (string, error) {
+	var h func() hash.Hash
+	switch strings.TrimSuffix(c.algorithm, "-sess") {
+	case "SHA-256":
+		h = sha256.New
+	default:
+		h = md5.New
+	}
+	digest := func(parts ...string) string {
+		d := h()
+		io.WriteString(d, strings.Join(parts, ":"))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	cnonce := make([]byte, 16)
+	if _, err := rand.Read(cnonce); err != nil {
+		return "", err
+	}
+	c.count++
+	nc := fmt.Sprintf("%08x", c.count)
+	cn := hex.EncodeToString(cnonce)
+
+	ha1 := digest(creds.user, c.realm, creds.password)
+	if strings.HasSuffix(c.algorithm, "-sess") {
+		ha1 = digest(ha1, c.nonce, cn)
+	}
+	ha2 := digest(method, uri)
+	response := digest(ha1, c.nonce, ha2)
+	if c.qop != "" {
+		response = digest(ha1, c.nonce, nc, cn, c.qop, ha2)
+	}
+
+	user := creds.user
+	if c.userhash {
+		user = digest(creds.user, c.realm)
+	}
+	fields := []string{
+		fmt.Sprintf("username=%q", user),
+		fmt.Sprintf("realm=%q", c.realm),
+		fmt.Sprintf("nonce=%q", c.nonce),
+		fmt.Sprintf("uri=%q", uri),
+		"algorithm=" + c.algorithm,
+		fmt.Sprintf("response=%q", response),
+	}
+	if c.opaque != "" {
+		fields = append(fields, fmt.Sprintf("opaque=%q", c.opaque))
+	}
+	if c.qop != "" {
+		fields = append(fields, "qop="+c.qop, "nc="+nc, fmt.Sprintf("cnonce=%q", cn))
+	}
+	if c.userhash {
+		fields = append(fields, "userhash=true")
+	}
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// digestTransport answers digest challenges of requests carrying digest credentials in their context.
+// The last challenge of every host is reused for following requests, counting the uses of its nonce,
+// so only the first request to a host and requests with a stale nonce are sent twice.
+type digestTransport struct {
+	next http.RoundTripper
+
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge
+}
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, ok := req.Context().Value(digestKey{}).(digestCredentials)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	req, err := replayable(req)
+	if err != nil {
+		return nil, err
+	}
+
+	key := req.URL.Host + " " + creds.user
+	t.mu.Lock()
+	challenge := t.challenges[key]
+	t.mu.Unlock()
+
+	resp, err := t.authorized(req, creds, challenge)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge, ok = parseDigestChallenge(resp.Header)
+	if !ok {
+		return resp, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	t.mu.Lock()
+	if t.challenges == nil {
+		t.challenges = make(map[string]*digestChallenge)
+	}
+	t.challenges[key] = challenge
+	t.mu.Unlock()
+	return t.authorized(req, creds, challenge)
+}
+
+// authorized sends a copy of req answering challenge, or req itself if there is no challenge yet
+func (t *digestTransport) authorized(req *http.Request, creds digestCredentials, challenge *digestChallenge) (*http.Response, error) {
+	if challenge == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	t.mu.Lock()
+	authorization, err := challenge.authorization(creds, req.Method, req.URL.RequestURI())
+	t.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", authorization)
+	if req.GetBody != nil {
+		if authReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(authReq)
+}
+
+// replayable returns req if its body can be sent again, or a copy of req with a buffered body. req itself is
+// not modified, as a RoundTripper must not change the requests it is given.
+func replayable(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return req, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	clone.Body, _ = clone.GetBody()
+	return clone, nil
+}
@@ -4,42 +4,173 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 )
 
 // EnvironmentFileName is the default name for the environments file from inteliJ
 const EnvironmentFileName = "rest-client.env.json"
 
+// PrivateEnvironmentFileName is the name of the environments file holding secrets, which is not meant to be committed
+const PrivateEnvironmentFileName = "rest-client.private.env.json"
+
 // EnvFile is a Helper type to parse the environments file into
-type EnvFile map[string]map[string]string
+type EnvFile map[string]EnvFileEntry
+
+// EnvFileEntry is an environment of an environments file
+type EnvFileEntry struct {
+	Variables map[string]string
+	// Auth are the raw auth configurations of the Security section by their ID. They are decoded once the
+	// public and private environments files are merged.
+	Auth map[string]json.RawMessage
+}
+
+// UnmarshalJSON decodes the variables of an environment and the auth configurations of its Security section.
+// Variables which are numbers or booleans are given as they are written.
+func (e *EnvFileEntry) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	e.Variables = make(map[string]string, len(fields))
+	for key, raw := range fields {
+		if key == "Security" {
+			var security struct {
+				Auth map[string]json.RawMessage
+			}
+			if err := json.Unmarshal(raw, &security); err != nil {
+				return fmt.Errorf("Security: %w", err)
+			}
+			e.Auth = security.Auth
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			var any interface{}
+			if err := json.Unmarshal(raw, &any); err != nil {
+				return err
+			}
+			switch any.(type) {
+			case float64, bool:
+				value = string(raw)
+			default:
+				return fmt.Errorf("variable %s is neither a string, number nor boolean", key)
+			}
+		}
+		e.Variables[key] = value
+	}
+	return nil
+}
+
+// Environment holds the variables of an environment
+type Environment struct {
+	Variables map[string]string
+	// Secrets are the values of the variables defined in the private environments file and the
+	// client secrets and passwords of the auth configurations
+	Secrets []string
+	// Auth are the auth configurations by their ID
+	Auth map[string]AuthConfig
+}
 
 // ReadEnvironment gets the environment variables from the default file location returns nil if it does not exist
 func ReadEnvironment(name string) (map[string]string, error) {
+	env, err := LoadEnvironment(name)
+	return env.Variables, err
+}
+
+// LoadEnvironment reads an environment from the environments file and the private environments file. Variables
+// of the private file override those of the public file and are marked as secrets. Fields of auth configurations
+// given in the private file override those of the public file.
+func LoadEnvironment(name string) (Environment, error) {
+	var env Environment
 	if name == "" {
-		return nil, nil
+		return env, nil
 	}
 
-	if _, err := os.Stat(EnvironmentFileName); err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
+	public, foundPublic, err := readEnvFile(EnvironmentFileName, name)
+	if err != nil {
+		return env, err
+	}
+	private, foundPrivate, err := readEnvFile(PrivateEnvironmentFileName, name)
+	if err != nil {
+		return env, err
+	}
+	if public == nil && private == nil {
+		return env, nil
+	}
+	if !foundPublic && !foundPrivate {
+		return env, fmt.Errorf("environment %s does not exist in file", name)
+	}
+
+	env.Variables = make(map[string]string)
+	for _, entry := range []*EnvFileEntry{public, private} {
+		if entry == nil {
+			continue
+		}
+		for key, value := range entry.Variables {
+			env.Variables[key] = value
+			if entry == private && value != "" {
+				env.Secrets = append(env.Secrets, value)
+			}
 		}
-		return nil, err
 	}
 
-	var fileStruct EnvFile
+	for _, entry := range []*EnvFileEntry{public, private} {
+		if entry == nil {
+			continue
+		}
+		for id, raw := range entry.Auth {
+			if env.Auth == nil {
+				env.Auth = make(map[string]AuthConfig)
+			}
+			config := env.Auth[id]
+			if err := json.Unmarshal(raw, &config); err != nil {
+				return env, fmt.Errorf("auth configuration %s: %w", id, err)
+			}
+			env.Auth[id] = config
+		}
+	}
+	for id, config := range env.Auth {
+		config = config.resolve(env.Variables)
+		for _, secret := range []string{config.ClientSecret, config.Password} {
+			if secret != "" {
+				env.Secrets = append(env.Secrets, secret)
+			}
+		}
+		env.Auth[id] = config
+	}
+	return env, nil
+}
 
-	f, err := os.Open(EnvironmentFileName)
+// readEnvFile reads the environment name from an environments file. It returns nil if the file does not exist
+// and reports whether the file defines the environment.
+func readEnvFile(fileName, name string) (*EnvFileEntry, bool, error) {
+	f, err := os.Open(fileName)
 	if err != nil {
-		return nil, err
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
 	}
 	defer f.Close()
-	if err := json.NewDecoder(f).Decode(&fileStruct); err != nil {
-		return nil, err
-	}
 
-	if env, ok := fileStruct[name]; ok {
-		return env, nil
+	var fileStruct EnvFile
+	if err := json.NewDecoder(f).Decode(&fileStruct); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", fileName, err)
 	}
 
-	return nil, fmt.Errorf("environment %s does not exist in file", name)
+	env, ok := fileStruct[name]
+	return &env, ok, nil
+}
 
+// replaceVariables replaces the {{name}} references of variables in text
+func replaceVariables(text string, vars map[string]string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	for key, value := range vars {
+		text = strings.Replace(text, "{{"+key+"}}", value, -1)
+	}
+	return text
 }
@@ -0,0 +1,120 @@
+package runtime
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// ErrorKind classifies why a request could not be executed
+type ErrorKind string
+
+const (
+	// ErrorKindDNS means the host name of the request could not be resolved
+	ErrorKindDNS ErrorKind = "dns"
+	// ErrorKindConnect means no connection to the server could be established
+	ErrorKindConnect ErrorKind = "connect"
+	// ErrorKindTLS means the TLS handshake with the server failed
+	ErrorKindTLS ErrorKind = "tls"
+	// ErrorKindConnectTimeout means no connection to the server was established in time
+	ErrorKindConnectTimeout ErrorKind = "connect-timeout"
+	// ErrorKindTimeout means the request did not finish in time
+	ErrorKindTimeout ErrorKind = "timeout"
+	// ErrorKindDeadline means the deadline of the whole run passed while the request was running
+	ErrorKindDeadline ErrorKind = "deadline"
+	// ErrorKindPollTimeout means the condition of a poll-until directive did not hold in time
+	ErrorKindPollTimeout ErrorKind = "poll-timeout"
+	// ErrorKindCanceled means the run was interrupted while the request was running
+	ErrorKindCanceled ErrorKind = "canceled"
+	// ErrorKindRedirect means the request exceeded the maximum number of redirects
+	ErrorKindRedirect ErrorKind = "redirect"
+	// ErrorKindAuth means no access token could be obtained for the request
+	ErrorKindAuth ErrorKind = "auth"
+	// ErrorKindFile means a file the request reads or writes could not be accessed
+	ErrorKindFile ErrorKind = "file"
+	// ErrorKindScript means the response handler file of the request does not exist or a client.global.set
+	// call of the handler of the auth provider could not be evaluated
+	ErrorKindScript ErrorKind = "script"
+	// ErrorKindOther is used for all errors not covered by the other kinds
+	ErrorKindOther ErrorKind = "other"
+)
+
+// ErrorMode decides how Do continues after a request failed
+type ErrorMode int
+
+const (
+	// ErrorModeSkipDependents skips the requests depending on a failed request and runs all others
+	ErrorModeSkipDependents ErrorMode = iota
+	// ErrorModeFailFast skips all requests which did not start before the first failure
+	ErrorModeFailFast
+	// ErrorModeContinue runs all requests even if requests they depend on failed
+	ErrorModeContinue
+)
+
+var errTooManyRedirects = errors.New("too many redirects")
+
+// Error is the structured form of an error which prevented a request from getting a response
+type Error struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e *Error) Error() string {
+	return string(e.Kind) + ": " + e.Message
+}
+
+// NewError classifies err into an Error
+func NewError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Kind: errorKind(err), Message: err.Error()}
+}
+
+func errorKind(err error) ErrorKind {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var pathErr *os.PathError
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorKindDNS
+	case errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout(), isTLSHandshakeTimeout(err):
+		return ErrorKindConnectTimeout
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorKindTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorKindCanceled
+	case isTLSError(err):
+		return ErrorKindTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrorKindConnect
+	case errors.Is(err, errTooManyRedirects):
+		return ErrorKindRedirect
+	case errors.As(err, &pathErr):
+		return ErrorKindFile
+	}
+	return ErrorKindOther
+}
+
+func isTLSHandshakeTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() && strings.Contains(err.Error(), "TLS handshake timeout")
+}
+
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &recordErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) ||
+		strings.Contains(err.Error(), "tls: ")
+}
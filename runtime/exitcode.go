@@ -0,0 +1,57 @@
+package runtime
+
+// Exit codes of rest-cli
+const (
+	// ExitOK means all requests succeeded
+	ExitOK = 0
+	// ExitError means rest-cli could not run the requests, e.g. because of invalid flags
+	ExitError = 1
+	// ExitParseError means the request file could not be parsed
+	ExitParseError = 2
+	// ExitTransportError means at least one request could not be executed
+	ExitTransportError = 3
+	// ExitAssertionFailure means at least one response differs from or could not be compared to its
+	// previous response, a poll-until condition did not hold in time or a response handler failed
+	ExitAssertionFailure = 4
+	// ExitStatusFailure means at least one response has a status code the run is set to fail on
+	ExitStatusFailure = 5
+	// ExitSkipped means requests were skipped because requests they depend on failed
+	ExitSkipped = 6
+	// ExitInterrupted means the run was interrupted by SIGINT or SIGTERM
+	ExitInterrupted = 130
+)
+
+// ExitCode computes the exit code of a run from its responses. Status codes only fail the run if they
+// are part of failOn. If several kinds of failures occurred transport errors take precedence over
+// assertion failures, which take precedence over status failures and skipped requests.
+func ExitCode(responses []Response, failOn StatusSet) int {
+	transport, assertion, status, skipped := false, false, false, false
+	for _, resp := range responses {
+		switch {
+		case resp.Error != nil && (resp.Error.Kind == ErrorKindPollTimeout || resp.Error.Kind == ErrorKindScript):
+			assertion = true
+		case resp.Error != nil && resp.Error.Kind == ErrorKindFile && resp.ComparedTo != "":
+			assertion = true
+		case resp.Error != nil:
+			transport = true
+		case resp.Skipped != "":
+			skipped = true
+		case len(resp.Differences) > 0:
+			assertion = true
+		case failOn.Contains(resp.ReturnCode):
+			status = true
+		}
+	}
+
+	switch {
+	case transport:
+		return ExitTransportError
+	case assertion:
+		return ExitAssertionFailure
+	case status:
+		return ExitStatusFailure
+	case skipped:
+		return ExitSkipped
+	}
+	return ExitOK
+}
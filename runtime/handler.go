@@ -0,0 +1,149 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"intelirest-cli/parser"
+)
+
+// globalSet matches the client.global.set("name", expression) calls of response handlers
+var globalSet = regexp.MustCompile(`client\.global\.set\(\s*["']([^"']+)["']\s*,\s*(.+?)\s*\)\s*(?:;|$)`)
+
+// valueOf matches the response.headers.valueOf("name") expression of response handlers
+var valueOf = regexp.MustCompile(`^response\.headers\.valueOf\(\s*["']([^"']+)["']\s*\)$`)
+
+// globals are the variables set by response handlers during a run. They are safe for concurrent use.
+type globals struct {
+	mu   sync.Mutex
+	vars map[string]string
+}
+
+func (g *globals) set(name, value string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.vars == nil {
+		g.vars = make(map[string]string)
+	}
+	g.vars[name] = value
+}
+
+// replace replaces the references of global variables in text
+func (g *globals) replace(text string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return replaceVariables(text, g.vars)
+}
+
+// runHandler sets the global variables of the client.global.set calls of the response handler of the auth
+// provider. Without a JavaScript engine, only values given as string literal, response.status,
+// response.headers.valueOf("name"), response.body or a property path into a JSON body like
+// response.body.json.token are supported. Other statements of the handler are ignored. The values are
+// added to the redactor of the client, as they usually are session tokens.
+func (c *Client) runHandler(req parser.Request, resp *Response) error {
+	script := req.ResponseHandler
+	if req.ResponseHandlerFile != "" {
+		content, err := ioutil.ReadFile(req.Path(req.ResponseHandlerFile))
+		if err != nil {
+			return &Error{Kind: ErrorKindScript, Message: err.Error()}
+		}
+		script = string(content)
+	}
+
+	for _, line := range strings.Split(script, "\n") {
+		for _, call := range globalSet.FindAllStringSubmatch(line, -1) {
+			value, err := handlerValue(call[2], resp)
+			if err != nil {
+				return &Error{Kind: ErrorKindScript, Message: fmt.Sprintf("can not set %s: %s", call[1], err)}
+			}
+			c.redactor.Add(value)
+			c.globals.set(call[1], value)
+		}
+	}
+	return nil
+}
+
+// handlerValue evaluates the value expression of a client.global.set call for resp
+func handlerValue(expr string, resp *Response) (string, error) {
+	if len(expr) >= 2 && (expr[0] == '"' || expr[0] == '\'') && expr[len(expr)-1] == expr[0] {
+		return expr[1 : len(expr)-1], nil
+	}
+	if expr == "response.status" {
+		return strconv.Itoa(resp.ReturnCode), nil
+	}
+	if match := valueOf.FindStringSubmatch(expr); match != nil {
+		return resp.Header.Get(match[1]), nil
+	}
+	if expr != "response.body" && !strings.HasPrefix(expr, "response.body.") && !strings.HasPrefix(expr, "response.body[") {
+		return "", fmt.Errorf("unsupported expression %s", expr)
+	}
+
+	body, err := responseBody(resp)
+	if err != nil {
+		return "", err
+	}
+	if expr == "response.body" {
+		return string(body), nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("the body is no JSON")
+	}
+	value, found, err := lookupJSONPath(doc, "$"+strings.TrimPrefix(expr, "response.body"))
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("the body has no %s", expr)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	formatted, _ := json.Marshal(value)
+	return string(formatted), nil
+}
+
+// substitute applies replace to the URL, headers, body and file names of a request and its parts
+func substitute(req parser.Request, replace func(string) string) (parser.Request, error) {
+	if rawURL := replace(req.RawURL); rawURL != req.RawURL {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return req, err
+		}
+		req.RawURL, req.URL = rawURL, *u
+	}
+	req.Headers = replaceAll(req.Headers, replace)
+	req.Body = replace(req.Body)
+	req.FileLoad = replace(req.FileLoad)
+	if req.Parts != nil {
+		parts := make([]parser.RequestPart, len(req.Parts))
+		for i, part := range req.Parts {
+			part.Headers = replaceAll(part.Headers, replace)
+			part.Body = replace(part.Body)
+			part.FileLoad = replace(part.FileLoad)
+			parts[i] = part
+		}
+		req.Parts = parts
+	}
+	if req.Output != nil {
+		output := *req.Output
+		output.Path = replace(output.Path)
+		req.Output = &output
+	}
+	return req, nil
+}
+
+// replaceAll returns a copy of the headers with replace applied to their values
+func replaceAll(headers map[string]string, replace func(string) string) map[string]string {
+	replaced := make(map[string]string, len(headers))
+	for key, value := range headers {
+		replaced[key] = replace(value)
+	}
+	return replaced
+}
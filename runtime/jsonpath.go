@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookupJSONPath returns the value at a JSON path like $.items[0].id or $['some key'] of a decoded
+// JSON document. It reports whether the document contains the path.
+func lookupJSONPath(doc interface{}, path string) (interface{}, bool, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, false, fmt.Errorf("JSON path \"%s\" does not start with $", path)
+	}
+
+	value := doc
+	rest := path[1:]
+	for rest != "" {
+		var key string
+		index := -1
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key, rest = rest[1:end+1], rest[end+1:]
+			if key == "" {
+				return nil, false, fmt.Errorf("empty key in JSON path \"%s\"", path)
+			}
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, false, fmt.Errorf("unterminated [ in JSON path \"%s\"", path)
+			}
+			selector := rest[1:end]
+			rest = rest[end+1:]
+			if n, err := strconv.Atoi(selector); err == nil {
+				index = n
+			} else if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0] {
+				key = selector[1 : len(selector)-1]
+			} else {
+				return nil, false, fmt.Errorf("invalid selector [%s] in JSON path \"%s\"", selector, path)
+			}
+		default:
+			return nil, false, fmt.Errorf("unexpected \"%s\" in JSON path \"%s\"", rest, path)
+		}
+
+		var ok bool
+		if index >= 0 {
+			var items []interface{}
+			if items, ok = value.([]interface{}); !ok || index >= len(items) {
+				return nil, false, nil
+			}
+			value = items[index]
+			continue
+		}
+
+		var object map[string]interface{}
+		if object, ok = value.(map[string]interface{}); !ok {
+			return nil, false, nil
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false, nil
+		}
+	}
+
+	return value, true, nil
+}
@@ -0,0 +1,144 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the verbosity of a Logger
+type LogLevel int
+
+const (
+	// LogOff logs nothing
+	LogOff LogLevel = iota
+	// LogRequests logs a line for each request and its outcome
+	LogRequests
+	// LogHeaders additionally logs the headers and bodies of requests and responses
+	LogHeaders
+	// LogWire additionally dumps the HTTP traffic as sent and received, including redirects
+	LogWire
+)
+
+// Logger writes the log of a client. It is safe for concurrent use.
+type Logger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	level    LogLevel
+	redactor *Redactor
+}
+
+// NewLogger creates a logger writing messages up to the given level to w
+func NewLogger(w io.Writer, level LogLevel) *Logger {
+	return &Logger{w: w, level: level}
+}
+
+// SetRedactor redacts the secrets known to redactor from all messages
+func (l *Logger) SetRedactor(redactor *Redactor) {
+	l.redactor = redactor
+}
+
+// Enabled reports whether messages of the given level are written
+func (l *Logger) Enabled(level LogLevel) bool {
+	return l != nil && level != LogOff && level <= l.level
+}
+
+// Logf writes a message of the given level prefixed with the time. Every line of multi-line messages is indented.
+func (l *Logger) Logf(level LogLevel, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	msg := strings.TrimRight(l.redactor.String(fmt.Sprintf(format, args...)), "\n")
+	msg = strings.Replace(msg, "\n", "\n    ", -1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "%s %s\n", time.Now().Format("15:04:05.000"), msg)
+}
+
+// formatHeader formats the fields of a header as sorted lines of `Key: value`
+func formatHeader(header http.Header) string {
+	lines := make([]string, 0, len(header))
+	for key, values := range header {
+		for _, value := range values {
+			lines = append(lines, key+": "+value)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// formatBody formats a body for the log, summarizing binary bodies
+func formatBody(body []byte, binary bool) string {
+	switch {
+	case len(body) == 0:
+		return ""
+	case binary:
+		return fmt.Sprintf("<binary body, %d bytes>", len(body))
+	}
+	return string(body)
+}
+
+type loggerKey struct{}
+
+// maxWireBody is the number of bytes of a response body dumped to the log, so large downloads like those
+// saved with >> are neither logged nor kept in memory as a whole
+const maxWireBody = 64 << 10
+
+// withWireLogger makes the transport of the client dump the traffic of the request to logger
+func withWireLogger(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// wireLogTransport dumps requests and responses to the logger found in the request context
+type wireLogTransport struct {
+	next http.RoundTripper
+}
+
+func (t *wireLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger, ok := req.Context().Value(loggerKey{}).(*Logger)
+	if !ok || !logger.Enabled(LogWire) {
+		return t.next.RoundTrip(req)
+	}
+
+	if dump, err := httputil.DumpRequestOut(req, true); err == nil {
+		logger.Logf(LogWire, "> wire\n%s", dump)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		logger.Logf(LogWire, "< wire error: %s", err)
+		return nil, err
+	}
+	if dump, err := httputil.DumpResponse(resp, false); err == nil {
+		body, truncated := peekBody(resp, maxWireBody)
+		note := ""
+		if truncated {
+			note = fmt.Sprintf("\n<body truncated after %d bytes>", maxWireBody)
+		}
+		logger.Logf(LogWire, "< wire\n%s%s%s", dump, body, note)
+	}
+	return resp, nil
+}
+
+// peekBody reads up to n bytes of the body of resp, which is replaced by a body returning them again
+// followed by the rest. It reports whether the body is longer than n bytes.
+func peekBody(resp *http.Response, n int64) ([]byte, bool) {
+	prefix, _ := ioutil.ReadAll(io.LimitReader(resp.Body, n+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), resp.Body), resp.Body}
+	if int64(len(prefix)) > n {
+		return prefix[:n], true
+	}
+	return prefix, false
+}
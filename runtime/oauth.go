@@ -0,0 +1,508 @@
+package runtime
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"intelirest-cli/parser"
+)
+
+// Grant types of OAuth 2.0 auth configurations, as named in IntelliJ environment files
+const (
+	GrantClientCredentials   = "Client Credentials"
+	GrantPassword            = "Password"
+	GrantAuthorizationCode   = "Authorization Code"
+	GrantDeviceAuthorization = "Device Authorization"
+)
+
+// DefaultDeviceInterval is the time between two token requests of the device flow if the server gives none
+const DefaultDeviceInterval = 5 * time.Second
+
+// AuthConfig is an OAuth 2.0 auth configuration of the Security section of an environment, referenced by
+// requests as {{$auth.token("id")}}. Values may reference variables of the environment.
+type AuthConfig struct {
+	Type          string `json:"Type"`
+	GrantType     string `json:"Grant Type"`
+	TokenURL      string `json:"Token URL"`
+	AuthURL       string `json:"Auth URL"`
+	RedirectURL   string `json:"Redirect URL"`
+	DeviceAuthURL string `json:"Device Auth URL"`
+	ClientID      string `json:"Client ID"`
+	ClientSecret  string `json:"Client Secret"`
+	// ClientCredentials sends the client ID and secret as basic authorization (basic, the default),
+	// as form values (in body) or only the client ID as form value (none)
+	ClientCredentials string `json:"Client Credentials"`
+	Scope             string `json:"Scope"`
+	Username          string `json:"Username"`
+	Password          string `json:"Password"`
+	PKCE              PKCE   `json:"PKCE"`
+}
+
+// PKCE configures the proof key for code exchange of the authorization code grant. It is given as boolean
+// or as object with a Code Challenge Method of S256, the default, or Plain.
+type PKCE struct {
+	Enabled bool
+	Method  string
+}
+
+// UnmarshalJSON decodes both forms of the PKCE setting
+func (p *PKCE) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.Enabled); err == nil {
+		return nil
+	}
+	var config struct {
+		Method string `json:"Code Challenge Method"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	p.Enabled, p.Method = true, config.Method
+	return nil
+}
+
+// resolve replaces the references of variables in the values of the configuration
+func (c AuthConfig) resolve(vars map[string]string) AuthConfig {
+	for _, field := range []*string{&c.TokenURL, &c.AuthURL, &c.RedirectURL, &c.DeviceAuthURL,
+		&c.ClientID, &c.ClientSecret, &c.Scope, &c.Username, &c.Password} {
+		*field = replaceVariables(*field, vars)
+	}
+	return c
+}
+
+// Token is an OAuth 2.0 token obtained for an auth configuration
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	// Expiry is the time the access token expires, zero if the server gave no lifetime
+	Expiry time.Time
+	// refreshAt is the time the access token is refreshed, a margin before its expiry
+	refreshAt time.Time
+}
+
+// tokenRefreshMargin is how long before their expiry tokens are refreshed, so requests do not send a token
+// which expires before the server checked it. Tokens living less than ten times as long are refreshed after
+// 90% of their lifetime instead.
+const tokenRefreshMargin = 30 * time.Second
+
+// tokenError is an error response of a token endpoint as described in RFC 6749 section 5.2
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *tokenError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// TokenManager obtains the tokens of auth configurations, caches them for the run and refreshes them
+// shortly before they expire. It is safe for concurrent use.
+type TokenManager struct {
+	configs  map[string]AuthConfig
+	client   *http.Client
+	progress io.Writer
+	// browse shows the authorization URL of the authorization code grant to the user
+	browse func(authURL string) error
+	now    func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]*Token
+	// locks serialise obtaining the token of each configuration, so waiting for the user to authorize one
+	// configuration does not block the requests using others
+	locks map[string]*sync.Mutex
+}
+
+// NewTokenManager creates a token manager for the auth configurations by their ID
+func NewTokenManager(configs map[string]AuthConfig) *TokenManager {
+	m := &TokenManager{
+		configs: configs,
+		client:  &http.Client{Timeout: time.Minute},
+		now:     time.Now,
+		tokens:  make(map[string]*Token),
+		locks:   make(map[string]*sync.Mutex),
+	}
+	m.browse = func(authURL string) error {
+		m.progressf("open %s in a browser to authorize the request\n", authURL)
+		return nil
+	}
+	return m
+}
+
+// SetProgress sets the writer the user is asked to authorize requests on, like for the device flow
+func (m *TokenManager) SetProgress(w io.Writer) {
+	m.progress = w
+}
+
+// Token returns the token of the auth configuration id, obtaining a new token if it has none or refreshing
+// it shortly before it expires
+func (m *TokenManager) Token(ctx context.Context, id string) (*Token, error) {
+	unlock := m.lock(id)
+	defer unlock()
+
+	config, ok := m.configs[id]
+	if !ok {
+		return nil, fmt.Errorf("auth configuration \"%s\" does not exist in the environment", id)
+	}
+	if config.Type != "" && !strings.EqualFold(config.Type, "OAuth2") {
+		return nil, fmt.Errorf("auth configuration \"%s\" has the unsupported type %s", id, config.Type)
+	}
+
+	m.mu.Lock()
+	token := m.tokens[id]
+	m.mu.Unlock()
+	if token != nil && (token.Expiry.IsZero() || m.now().Before(token.refreshAt)) {
+		return token, nil
+	}
+
+	var err error
+	if token != nil && token.RefreshToken != "" {
+		refreshed, refreshErr := m.requestToken(ctx, config, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {token.RefreshToken},
+		})
+		if refreshErr == nil {
+			if refreshed.RefreshToken == "" {
+				refreshed.RefreshToken = token.RefreshToken
+			}
+			m.store(id, refreshed)
+			return refreshed, nil
+		}
+		// Refresh tokens expire as well, in which case a new token is obtained
+	}
+
+	switch {
+	case strings.EqualFold(config.GrantType, GrantClientCredentials):
+		token, err = m.requestToken(ctx, config, url.Values{"grant_type": {"client_credentials"}})
+	case strings.EqualFold(config.GrantType, GrantPassword):
+		token, err = m.requestToken(ctx, config, url.Values{
+			"grant_type": {"password"},
+			"username":   {config.Username},
+			"password":   {config.Password},
+		})
+	case strings.EqualFold(config.GrantType, GrantAuthorizationCode):
+		token, err = m.authorizationCode(ctx, config)
+	case strings.EqualFold(config.GrantType, GrantDeviceAuthorization):
+		token, err = m.deviceAuthorization(ctx, config)
+	default:
+		err = fmt.Errorf("unsupported grant type \"%s\"", config.GrantType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("auth configuration \"%s\": %w", id, err)
+	}
+	m.store(id, token)
+	return token, nil
+}
+
+// lock locks obtaining the token of the auth configuration id and returns the function unlocking it
+func (m *TokenManager) lock(id string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		m.locks[id] = l
+	}
+	m.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (m *TokenManager) store(id string, token *Token) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[id] = token
+}
+
+// requestToken posts a token request with the given form values and the client credentials to the token URL
+func (m *TokenManager) requestToken(ctx context.Context, config AuthConfig, form url.Values) (*Token, error) {
+	if config.Scope != "" && form.Get("grant_type") != "authorization_code" {
+		form.Set("scope", config.Scope)
+	}
+
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	if err := m.post(ctx, config, config.TokenURL, form, &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("the token response has no access_token")
+	}
+
+	t := &Token{AccessToken: token.AccessToken, RefreshToken: token.RefreshToken, IDToken: token.IDToken}
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		margin := tokenRefreshMargin
+		if lifetime/10 < margin {
+			margin = lifetime / 10
+		}
+		t.Expiry = m.now().Add(lifetime)
+		t.refreshAt = t.Expiry.Add(-margin)
+	}
+	return t, nil
+}
+
+// post sends a form with the client credentials to an endpoint of the authorization server and decodes
+// the JSON response into v. Error responses are returned as tokenError.
+func (m *TokenManager) post(ctx context.Context, config AuthConfig, endpoint string, form url.Values, v interface{}) error {
+	if endpoint == "" {
+		return errors.New("the auth configuration has no URL for the grant")
+	}
+
+	basic := config.ClientSecret != "" && (config.ClientCredentials == "" || strings.EqualFold(config.ClientCredentials, "basic"))
+	if !basic {
+		form.Set("client_id", config.ClientID)
+		if config.ClientSecret != "" && strings.EqualFold(config.ClientCredentials, "in body") {
+			form.Set("client_secret", config.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		var tokenErr tokenError
+		if json.Unmarshal(body, &tokenErr) == nil && tokenErr.Code != "" {
+			return &tokenErr
+		}
+		return fmt.Errorf("%s answered %s", endpoint, statusLine(resp.StatusCode))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s answered no JSON: %w", endpoint, err)
+	}
+	return nil
+}
+
+// authorizationCode lets the user authorize the client in a browser and exchanges the code the browser
+// is redirected with for a token. The redirect is received on the host and port of the redirect URL.
+func (m *TokenManager) authorizationCode(ctx context.Context, config AuthConfig) (*Token, error) {
+	redirect, err := url.Parse(config.RedirectURL)
+	if err != nil || config.RedirectURL == "" {
+		redirect = &url.URL{Scheme: "http", Host: "127.0.0.1:0", Path: "/callback"}
+	}
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return nil, fmt.Errorf("can not receive the redirect: %w", err)
+	}
+	defer listener.Close()
+	if redirect.Port() == "0" || redirect.Port() == "" {
+		redirect.Host = listener.Addr().String()
+	}
+
+	state := randomString()
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {config.ClientID},
+		"redirect_uri":  {redirect.String()},
+		"state":         {state},
+	}
+	if config.Scope != "" {
+		query.Set("scope", config.Scope)
+	}
+	verifier := randomString()
+	if config.PKCE.Enabled {
+		if strings.EqualFold(config.PKCE.Method, "Plain") {
+			query.Set("code_challenge", verifier)
+			query.Set("code_challenge_method", "plain")
+		} else {
+			sum := sha256.Sum256([]byte(verifier))
+			query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+			query.Set("code_challenge_method", "S256")
+		}
+	}
+
+	codes := make(chan url.Values, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != redirect.Path && redirect.Path != "" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "The request was authorized, this window can be closed.")
+		select {
+		case codes <- r.URL.Query():
+		default:
+		}
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	authURL, err := url.Parse(config.AuthURL)
+	if err != nil || config.AuthURL == "" {
+		return nil, errors.New("the auth configuration has no valid Auth URL")
+	}
+	authURL.RawQuery = query.Encode()
+	if err := m.browse(authURL.String()); err != nil {
+		return nil, err
+	}
+
+	var params url.Values
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case params = <-codes:
+	}
+	if params.Get("error") != "" {
+		return nil, &tokenError{Code: params.Get("error"), Description: params.Get("error_description")}
+	}
+	if params.Get("state") != state {
+		return nil, errors.New("the authorization redirect has an invalid state")
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {params.Get("code")},
+		"redirect_uri": {redirect.String()},
+	}
+	if config.PKCE.Enabled {
+		form.Set("code_verifier", verifier)
+	}
+	return m.requestToken(ctx, config, form)
+}
+
+// deviceAuthorization asks the user to authorize the client on another device as described in RFC 8628
+// and polls the token URL until the user did
+func (m *TokenManager) deviceAuthorization(ctx context.Context, config AuthConfig) (*Token, error) {
+	form := url.Values{}
+	if config.Scope != "" {
+		form.Set("scope", config.Scope)
+	}
+	var device struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval"`
+	}
+	if err := m.post(ctx, config, config.DeviceAuthURL, form, &device); err != nil {
+		return nil, err
+	}
+
+	if device.VerificationURIComplete != "" {
+		m.progressf("open %s to authorize the request\n", device.VerificationURIComplete)
+	} else {
+		m.progressf("open %s and enter the code %s to authorize the request\n", device.VerificationURI, device.UserCode)
+	}
+
+	interval := DefaultDeviceInterval
+	if device.Interval > 0 {
+		interval = time.Duration(device.Interval) * time.Second
+	}
+	if device.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(device.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	for {
+		if !sleep(ctx, interval) {
+			return nil, fmt.Errorf("the device was not authorized: %w", ctx.Err())
+		}
+
+		token, err := m.requestToken(ctx, config, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {device.DeviceCode},
+		})
+		var tokenErr *tokenError
+		switch {
+		case err == nil:
+			return token, nil
+		case errors.As(err, &tokenErr) && tokenErr.Code == "authorization_pending":
+		case errors.As(err, &tokenErr) && tokenErr.Code == "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+	}
+}
+
+// progressf asks the user to authorize a request on the progress writer if one is set
+func (m *TokenManager) progressf(format string, args ...interface{}) {
+	if m.progress != nil {
+		fmt.Fprintf(m.progress, format, args...)
+	}
+}
+
+// randomString returns a random string usable as state or PKCE code verifier
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// authMacro matches the {{$auth.token("id")}} and {{$auth.idToken("id")}} references of tokens
+var authMacro = regexp.MustCompile(`\{\{\s*\$auth\.(token|idToken)\(\s*"([^"]*)"\s*\)\s*}}`)
+
+// resolveAuth replaces the references of tokens in the URL, headers and body of a request with the tokens
+// of the token manager of the client
+func (c *Client) resolveAuth(ctx context.Context, req parser.Request) (parser.Request, error) {
+	var err error
+	resolve := func(text string) string {
+		if err != nil || !strings.Contains(text, "$auth.") {
+			return text
+		}
+		return authMacro.ReplaceAllStringFunc(text, func(macro string) string {
+			match := authMacro.FindStringSubmatch(macro)
+			if c.tokens == nil {
+				err = errors.New("no auth configurations are defined in the environment")
+				return macro
+			}
+			token, tokenErr := c.tokens.Token(ctx, match[2])
+			if tokenErr != nil {
+				err = tokenErr
+				return macro
+			}
+			c.redactor.Add(token.AccessToken, token.IDToken, token.RefreshToken)
+			if match[1] == "idToken" {
+				return token.IDToken
+			}
+			return token.AccessToken
+		})
+	}
+
+	req, substErr := substitute(req, resolve)
+	if substErr != nil {
+		return req, substErr
+	}
+	if err != nil {
+		return req, &Error{Kind: ErrorKindAuth, Message: err.Error()}
+	}
+	return req, nil
+}
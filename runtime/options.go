@@ -0,0 +1,121 @@
+package runtime
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"intelirest-cli/parser"
+
+	"gopkg.in/resty.v1"
+)
+
+type connectTimeoutKey struct{}
+
+func withConnectTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, connectTimeoutKey{}, timeout)
+}
+
+// newTransport creates the transport shared by all requests of a client. Its dialer honours
+// the connection timeout a request carries in its context.
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if timeout, ok := ctx.Value(connectTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+			d := *dialer
+			d.Timeout = timeout
+			return d.DialContext(ctx, network, addr)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return transport
+}
+
+// newRestyClient creates a resty client on the shared transport. A nil jar disables cookie handling.
+func newRestyClient(transport http.RoundTripper, jar http.CookieJar) *resty.Client {
+	return resty.NewWithClient(&http.Client{Jar: jar}).
+		SetTransport(transport).
+		SetRedirectPolicy(redirectPolicy())
+}
+
+// clientFor selects the resty client matching the directives of the request
+func (c *Client) clientFor(req parser.Request) *resty.Client {
+	if req.HasOption(parser.OptionNoCookieJar) {
+		return c.noJarClient
+	}
+	return c.client
+}
+
+// requestContext applies the timeouts of the request to ctx. Timeout directives take
+// precedence over the timeouts of the client.
+func (c *Client) requestContext(ctx context.Context, req parser.Request) (context.Context, context.CancelFunc, error) {
+	timeout, connectTimeout := c.timeout, c.connectTimeout
+	if opt, ok := req.Option(parser.OptionTimeout); ok {
+		var err error
+		if timeout, err = opt.Duration(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opt, ok := req.Option(parser.OptionConnectionTimeout); ok {
+		var err error
+		if connectTimeout, err = opt.Duration(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cancel := func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	if connectTimeout > 0 {
+		ctx = withConnectTimeout(ctx, connectTimeout)
+	}
+
+	return ctx, cancel, nil
+}
+
+// requestURL returns the URL to send the request to. Unless the request carries the
+// no-auto-encoding directive, characters not allowed in a query are percent encoded.
+func requestURL(req parser.Request) string {
+	if req.HasOption(parser.OptionNoAutoEncoding) {
+		return req.RawURL
+	}
+
+	u := req.URL
+	u.RawQuery = encodeQuery(u.RawQuery)
+	return u.String()
+}
+
+// encodeQuery percent encodes all bytes of a raw query which are neither unreserved nor
+// delimiters. Existing escapes are kept so already encoded values are not encoded twice.
+func encodeQuery(query string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if shouldEscapeInQuery(ch) {
+			b.WriteByte('%')
+			b.WriteByte(hex[ch>>4])
+			b.WriteByte(hex[ch&15])
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+func shouldEscapeInQuery(ch byte) bool {
+	switch {
+	case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9':
+		return false
+	}
+	return !strings.ContainsRune("-._~!$&'()*+,;=:@/?%", rune(ch))
+}
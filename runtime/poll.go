@@ -0,0 +1,124 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"intelirest-cli/parser"
+)
+
+const (
+	// DefaultPollInterval is the time between two requests of a poll-until directive without interval
+	DefaultPollInterval = 2 * time.Second
+	// DefaultPollTimeout is the time a poll-until directive without timeout waits for its condition
+	DefaultPollTimeout = 2 * time.Minute
+)
+
+// poll repeats the request with send until the condition of the poll-until directive holds for its
+// response. Every poll is reported to the progress writer of the client. If the condition does not hold
+// before the timeout of the directive, the last response is returned with an ErrorKindPollTimeout error.
+func (c *Client) poll(ctx context.Context, req parser.Request, resp *Response, send func() (*Response, error)) (*Response, error) {
+	opt, _ := req.Option(parser.OptionPollUntil)
+	cond, err := opt.PollUntil()
+	if err != nil {
+		return nil, err
+	}
+	interval, timeout := cond.Interval, cond.Timeout
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+	if timeout == 0 {
+		timeout = DefaultPollTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	for polls := 1; ; polls++ {
+		resp.Polls = polls
+		actual, holds, err := pollCondition(cond, resp)
+		if err != nil {
+			return nil, err
+		}
+		c.progressf("polling \"%s\": %s is %s (request %d)\n", req.Name, cond.Subject, actual, polls)
+		if holds {
+			return resp, nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return resp, &Error{
+				Kind:    ErrorKindPollTimeout,
+				Message: fmt.Sprintf("%s %s %s did not hold within %s", cond.Subject, cond.Operator, cond.Value, timeout),
+			}
+		}
+		if !sleep(ctx, interval) {
+			kind := ErrorKindCanceled
+			if ctx.Err() == context.DeadlineExceeded {
+				kind = ErrorKindDeadline
+			}
+			return resp, &Error{Kind: kind, Message: fmt.Sprintf("polling stopped: %s", ctx.Err())}
+		}
+
+		next, err := send()
+		if err != nil {
+			if next != nil {
+				next.Polls = polls + 1
+			}
+			return next, err
+		}
+		resp = next
+	}
+}
+
+// pollCondition evaluates the condition for resp. It returns the value of the subject formatted
+// for progress output and whether the condition holds.
+func pollCondition(cond parser.PollUntil, resp *Response) (string, bool, error) {
+	var equal bool
+	var actual string
+	if cond.Subject == "status" {
+		statuses, err := ParseStatusSet([]string{cond.Value})
+		if err != nil {
+			return "", false, err
+		}
+		actual = fmt.Sprint(resp.ReturnCode)
+		equal = statuses.Contains(resp.ReturnCode)
+	} else {
+		body, err := responseBody(resp)
+		if err != nil {
+			return "", false, &Error{Kind: ErrorKindFile, Message: err.Error()}
+		}
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "not JSON", false, nil
+		}
+		value, found, err := lookupJSONPath(doc, cond.Subject)
+		if err != nil {
+			return "", false, err
+		}
+		if !found {
+			return "missing", false, nil
+		}
+
+		var expected interface{}
+		if err := json.Unmarshal([]byte(cond.Value), &expected); err != nil {
+			// Unquoted values which are no JSON literal are compared as strings
+			expected = cond.Value
+		}
+		formatted, _ := json.Marshal(value)
+		actual = string(formatted)
+		equal = reflect.DeepEqual(value, expected)
+	}
+
+	if cond.Operator == "!=" {
+		return actual, !equal, nil
+	}
+	return actual, equal, nil
+}
+
+// progressf writes progress information of long running requests to the progress writer if one is set
+func (c *Client) progressf(format string, args ...interface{}) {
+	if c.progress != nil {
+		fmt.Fprint(c.progress, c.redactor.String(fmt.Sprintf(format, args...)))
+	}
+}
@@ -0,0 +1,280 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces sensitive values in the output and the log
+const Redacted = "[redacted]"
+
+// SensitiveHeaders are the header fields whose values are always redacted
+var SensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// Redactor removes secrets from responses and log messages. A nil Redactor redacts nothing.
+// It is safe for concurrent use.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  []string
+	seen     map[string]bool
+	patterns []*regexp.Regexp
+	headers  map[string]bool
+	// headerLines matches lines of sensitive header fields in log messages and wire dumps
+	headerLines *regexp.Regexp
+}
+
+// NewRedactor creates a redactor for the values of sensitive headers, the given secret values and the
+// matches of the given regular expressions
+func NewRedactor(secrets []string, patterns []string) (*Redactor, error) {
+	r := &Redactor{headers: make(map[string]bool, len(SensitiveHeaders)), seen: make(map[string]bool)}
+	r.Add(secrets...)
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern \"%s\": %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	names := make([]string, 0, len(SensitiveHeaders))
+	for _, name := range SensitiveHeaders {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	r.headerLines = regexp.MustCompile(`(?im)^(` + strings.Join(names, "|") + `):[ \t]*[^\r\n]*`)
+	return r, nil
+}
+
+// Add adds secret values which only become known during the run, like obtained tokens
+func (r *Redactor) Add(secrets ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	added := false
+	for _, secret := range secrets {
+		if !redactable(secret) {
+			continue
+		}
+		// Secrets are also searched as they appear in JSON strings
+		quoted, _ := json.Marshal(secret)
+		for _, s := range []string{secret, string(quoted[1 : len(quoted)-1])} {
+			if s != "" && !r.seen[s] {
+				r.seen[s] = true
+				r.secrets = append(r.secrets, s)
+				added = true
+			}
+		}
+	}
+	if added {
+		// Longer secrets first so secrets containing others are replaced as a whole
+		sort.SliceStable(r.secrets, func(i, j int) bool {
+			return len(r.secrets[i]) > len(r.secrets[j])
+		})
+	}
+}
+
+// minSecretLength is the length of the shortest secret value which is redacted
+const minSecretLength = 4
+
+// redactable reports whether a secret value is redacted. Short values, numbers and booleans, like a port
+// or a flag of the private environment, would replace unrelated parts of the output.
+func redactable(secret string) bool {
+	if len(secret) < minSecretLength {
+		return false
+	}
+	if _, err := strconv.ParseFloat(secret, 64); err == nil {
+		return false
+	}
+	_, err := strconv.ParseBool(secret)
+	return err != nil
+}
+
+// String redacts the secrets, pattern matches and sensitive header lines in s
+func (r *Redactor) String(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+
+	r.mu.RLock()
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, Redacted, -1)
+	}
+	r.mu.RUnlock()
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, Redacted)
+	}
+	return r.headerLines.ReplaceAllString(s, "$1: "+Redacted)
+}
+
+// Header returns a copy of h with the values of sensitive fields redacted
+func (r *Redactor) Header(h http.Header) http.Header {
+	if r == nil || h == nil {
+		return h
+	}
+
+	out := make(http.Header, len(h))
+	for key, values := range h {
+		redacted := make([]string, len(values))
+		for i, value := range values {
+			if r.headers[http.CanonicalHeaderKey(key)] {
+				redacted[i] = Redacted
+			} else {
+				redacted[i] = r.String(value)
+			}
+		}
+		out[key] = redacted
+	}
+	return out
+}
+
+// Response returns a copy of resp with secrets redacted from its URL, headers, cookies, errors and body
+func (r *Redactor) Response(resp Response) Response {
+	if r == nil {
+		return resp
+	}
+
+	resp.URL = r.String(resp.URL)
+	resp.Skipped = r.String(resp.Skipped)
+	resp.Error = r.error(resp.Error)
+	resp.Header = r.Header(resp.Header)
+	resp.Trailer = r.Header(resp.Trailer)
+
+	if resp.Cookies != nil {
+		cookies := make([]Cookie, len(resp.Cookies))
+		for i, cookie := range resp.Cookies {
+			cookie.Value = Redacted
+			cookies[i] = cookie
+		}
+		resp.Cookies = cookies
+	}
+	if resp.Redirects != nil {
+		redirects := make([]Redirect, len(resp.Redirects))
+		for i, redirect := range resp.Redirects {
+			redirect.URL = r.String(redirect.URL)
+			redirect.Location = r.String(redirect.Location)
+			redirects[i] = redirect
+		}
+		resp.Redirects = redirects
+	}
+	if resp.Attempts != nil {
+		attempts := make([]Attempt, len(resp.Attempts))
+		for i, attempt := range resp.Attempts {
+			attempt.Error = r.error(attempt.Error)
+			attempts[i] = attempt
+		}
+		resp.Attempts = attempts
+	}
+	if resp.Differences != nil {
+		differences := make([]Difference, len(resp.Differences))
+		for i, difference := range resp.Differences {
+			difference.Expected = r.value(difference.Expected)
+			difference.Actual = r.value(difference.Actual)
+			differences[i] = difference
+		}
+		resp.Differences = differences
+	}
+
+	if resp.Encoding != EncodingBase64 {
+		resp.Body, resp.Content = r.body(resp.Body, resp.Content)
+	}
+	return resp
+}
+
+// body redacts a text or JSON body and its Content
+func (r *Redactor) body(body []byte, content json.RawMessage) ([]byte, json.RawMessage) {
+	if len(body) == 0 {
+		return body, content
+	}
+
+	redacted := []byte(r.String(string(body)))
+	switch {
+	case len(content) == 0:
+	case content[0] == '"':
+		content, _ = json.Marshal(string(redacted))
+	case json.Valid(redacted):
+		content = json.RawMessage(redacted)
+	default:
+		// Patterns may break the JSON document, which is then given as string
+		content, _ = json.Marshal(string(redacted))
+	}
+	return redacted, content
+}
+
+// redactedError is an error whose message has the secrets of the wrapped error redacted
+type redactedError struct {
+	err     error
+	message string
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// RedactError returns err with the secrets of its message redacted, like the URLs of failed requests.
+// The returned error wraps err, so errors.As still finds the errors err wraps.
+func (r *Redactor) RedactError(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	return &redactedError{err: err, message: r.String(err.Error())}
+}
+
+func (r *Redactor) error(e *Error) *Error {
+	if e == nil {
+		return nil
+	}
+	return &Error{Kind: e.Kind, Message: r.String(e.Message)}
+}
+
+func (r *Redactor) value(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return r.String(s)
+	}
+	return v
+}
+
+type redactingReporter struct {
+	reporter Reporter
+	redactor *Redactor
+}
+
+// RedactingReporter wraps a reporter so it only sees responses redacted by redactor
+func RedactingReporter(reporter Reporter, redactor *Redactor) Reporter {
+	if redactor == nil {
+		return reporter
+	}
+	return &redactingReporter{reporter: reporter, redactor: redactor}
+}
+
+func (r *redactingReporter) Report(i int, resp Response) error {
+	return r.reporter.Report(i, r.redactor.Response(resp))
+}
+
+func (r *redactingReporter) Finish(responses []Response) error {
+	redacted := make([]Response, len(responses))
+	for i, resp := range responses {
+		redacted[i] = r.redactor.Response(resp)
+	}
+	return r.reporter.Finish(redacted)
+}
@@ -0,0 +1,82 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopkg.in/resty.v1"
+)
+
+// DefaultMaxRedirects is the number of redirects a request follows before it is aborted
+const DefaultMaxRedirects = 10
+
+// Redirect is a single hop of the redirect chain a request went through
+type Redirect struct {
+	URL        string
+	ReturnCode int
+	Location   string
+	Duration   time.Duration
+}
+
+type redirectKey struct{}
+
+// redirectRecorder decides whether a single request follows redirects and collects its chain.
+// It travels with the request context so the shared resty client can apply per request options.
+type redirectRecorder struct {
+	follow bool
+	max    int
+	start  time.Time
+	hops   []Redirect
+}
+
+func newRedirectRecorder(follow bool, max int) *redirectRecorder {
+	return &redirectRecorder{
+		follow: follow,
+		max:    max,
+		start:  time.Now(),
+		hops:   make([]Redirect, 0),
+	}
+}
+
+func withRedirectRecorder(ctx context.Context, rec *redirectRecorder) context.Context {
+	return context.WithValue(ctx, redirectKey{}, rec)
+}
+
+// redirectPolicy consults the redirectRecorder of the request context for every redirect
+// the client encounters. Requests without a recorder follow up to DefaultMaxRedirects.
+func redirectPolicy() resty.RedirectPolicy {
+	return resty.RedirectPolicyFunc(func(req *http.Request, via []*http.Request) error {
+		rec, ok := req.Context().Value(redirectKey{}).(*redirectRecorder)
+		if !ok {
+			rec = newRedirectRecorder(true, DefaultMaxRedirects)
+		}
+
+		if !rec.follow {
+			// Hand the redirect response itself back to the caller
+			return http.ErrUseLastResponse
+		}
+
+		if len(via) > rec.max {
+			return fmt.Errorf("%w: stopped after %d redirects", errTooManyRedirects, rec.max)
+		}
+
+		now := time.Now()
+		hop := Redirect{
+			URL:      via[len(via)-1].URL.String(),
+			Location: req.URL.String(),
+			Duration: now.Sub(rec.start),
+		}
+		if req.Response != nil {
+			hop.ReturnCode = req.Response.StatusCode
+			if loc := req.Response.Header.Get("Location"); loc != "" {
+				hop.Location = loc
+			}
+		}
+		rec.hops = append(rec.hops, hop)
+		rec.start = now
+
+		return nil
+	})
+}
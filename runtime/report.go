@@ -0,0 +1,82 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Reporter writes the responses of a run in an output format. Report is called with the index and the
+// response of each request as soon as it completed or was skipped, Finish once all requests completed
+// with the responses in the order of the requests. Reporters writing a single document only write in Finish.
+type Reporter interface {
+	Report(i int, resp Response) error
+	Finish(responses []Response) error
+}
+
+// Output formats of NewReporter
+const (
+	OutputPretty = "pretty"
+	OutputJSON   = "json"
+	OutputJSONL  = "jsonl"
+	OutputJUnit  = "junit"
+	OutputTAP    = "tap"
+	OutputHTTP   = "http"
+)
+
+// ReportOptions adjust the output of reporters
+type ReportOptions struct {
+	// Name is the name of the run, like the request file, used by formats grouping the requests
+	Name string
+	// Color enables ANSI colours of the pretty format
+	Color bool
+	// Legacy writes the json and jsonl formats in the LegacyResponse format
+	Legacy bool
+	// FailOn are the status codes failing a request, as they fail the run
+	FailOn StatusSet
+}
+
+// NewReporter creates the reporter for the given output format writing to w
+func NewReporter(format string, w io.Writer, opts ReportOptions) (Reporter, error) {
+	switch format {
+	case OutputPretty:
+		return &prettyReporter{w: w, color: opts.Color, failOn: opts.FailOn}, nil
+	case OutputJSON:
+		return &jsonReporter{w: w, legacy: opts.Legacy}, nil
+	case OutputJSONL:
+		return &jsonReporter{w: w, legacy: opts.Legacy, lines: true}, nil
+	case OutputJUnit:
+		return &junitReporter{w: w, name: opts.Name, failOn: opts.FailOn}, nil
+	case OutputTAP:
+		return &tapReporter{w: w, failOn: opts.FailOn}, nil
+	case OutputHTTP:
+		return &httpReporter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format \"%s\", expected pretty, json, jsonl, junit, tap or http", format)
+}
+
+// FormatDuration rounds a duration to a precision readable at a glance, showing phases which did not happen as -
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "-"
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(100 * time.Microsecond).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+// summary describes the outcome of a response in a single line, like 404 Not Found or skipped: reason
+func summary(resp Response) string {
+	switch {
+	case resp.Skipped != "":
+		return "skipped: " + resp.Skipped
+	case resp.Error != nil:
+		return "error: " + resp.Error.Error()
+	case len(resp.Differences) > 0:
+		return fmt.Sprintf("%d differences to %s", len(resp.Differences), resp.ComparedTo)
+	}
+	return statusLine(resp.ReturnCode)
+}
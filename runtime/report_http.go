@@ -0,0 +1,65 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// httpReporter writes each response in the HTTP/1.1 wire format as soon as it is available,
+// separated by ### lines naming the request. Bodies saved to a file are replaced by a comment naming it.
+type httpReporter struct {
+	w io.Writer
+}
+
+func (r *httpReporter) Report(_ int, resp Response) error {
+	w := bufio.NewWriter(r.w)
+	fmt.Fprintf(w, "### %s\n# %s %s\n", resp.Name, resp.Method, resp.URL)
+	if resp.Skipped != "" || resp.Error != nil {
+		fmt.Fprintf(w, "# %s\n\n", summary(resp))
+		return w.Flush()
+	}
+
+	proto := resp.HTTPVersion
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	fmt.Fprintf(w, "%s %s\r\n", proto, statusLine(resp.ReturnCode))
+	writeHeader(w, resp.Header)
+	w.WriteString("\r\n")
+	if resp.SavedTo != "" {
+		fmt.Fprintf(w, "# body saved to %s", resp.SavedTo)
+	} else {
+		w.Write(resp.Body)
+	}
+	if len(resp.Trailer) > 0 {
+		w.WriteString("\r\n")
+		writeHeader(w, resp.Trailer)
+	}
+	w.WriteString("\n\n")
+	return w.Flush()
+}
+
+func (r *httpReporter) Finish([]Response) error {
+	return nil
+}
+
+func writeHeader(w io.Writer, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(w, "%s: %s\r\n", key, value)
+		}
+	}
+}
+
+// statusLine formats a status code with its reason phrase, like 404 Not Found
+func statusLine(code int) string {
+	return fmt.Sprintf("%d %s", code, http.StatusText(code))
+}
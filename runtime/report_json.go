@@ -0,0 +1,42 @@
+package runtime
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonReporter writes all responses as indented JSON array once the run finished or, with lines set,
+// each response as a single line of JSON as soon as it is available
+type jsonReporter struct {
+	w      io.Writer
+	legacy bool
+	lines  bool
+}
+
+func (r *jsonReporter) Report(_ int, resp Response) error {
+	if !r.lines {
+		return nil
+	}
+	return json.NewEncoder(r.w).Encode(r.convert(resp))
+}
+
+func (r *jsonReporter) Finish(responses []Response) error {
+	if r.lines {
+		return nil
+	}
+
+	out := make([]interface{}, len(responses))
+	for i, resp := range responses {
+		out[i] = r.convert(resp)
+	}
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func (r *jsonReporter) convert(resp Response) interface{} {
+	if r.legacy {
+		return resp.Legacy()
+	}
+	return resp
+}
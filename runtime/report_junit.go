@@ -0,0 +1,101 @@
+package runtime
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// junitReporter writes the responses as JUnit XML test suite once the run finished. Each request is
+// a test case, requests with a status code of 400 or above or differences fail, requests which could
+// not be executed are errors.
+type junitReporter struct {
+	w      io.Writer
+	name   string
+	failOn StatusSet
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func (r *junitReporter) Report(int, Response) error {
+	return nil
+}
+
+func (r *junitReporter) Finish(responses []Response) error {
+	suite := junitTestSuite{Name: r.name, Tests: len(responses)}
+	var total float64
+	for _, resp := range responses {
+		seconds := 0.0
+		if resp.Timings != nil {
+			seconds = resp.Timings.Total.Seconds()
+		}
+		total += seconds
+
+		tc := junitTestCase{
+			Name:      resp.Name,
+			ClassName: strings.TrimSpace(resp.Method + " " + resp.URL),
+			Time:      fmt.Sprintf("%.3f", seconds),
+		}
+		switch {
+		case resp.Skipped != "":
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: resp.Skipped}
+		case resp.Error != nil:
+			suite.Errors++
+			tc.Error = &junitMessage{Message: resp.Error.Message, Type: string(resp.Error.Kind)}
+		case resp.Failed(r.failOn):
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: summary(resp), Type: "status"}
+			if len(resp.Differences) > 0 {
+				tc.Failure.Type = "differences"
+				lines := make([]string, 0, len(resp.Differences))
+				for _, d := range resp.Differences {
+					lines = append(lines, d.Path+" "+d.Kind)
+				}
+				tc.Failure.Text = strings.Join(lines, "\n")
+			}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total)
+
+	if _, err := io.WriteString(r.w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(r.w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(r.w, "\n")
+	return err
+}
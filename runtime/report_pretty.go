@@ -0,0 +1,170 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ANSI escape sequences used by the renderer
+const (
+	colorReset   = "\x1b[0m"
+	colorBold    = "\x1b[1m"
+	colorDim     = "\x1b[2m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorBlue    = "\x1b[34m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+)
+
+// prettyReporter prints responses for humans as soon as they are available
+type prettyReporter struct {
+	w      io.Writer
+	color  bool
+	failOn StatusSet
+	total  int
+	failed int
+}
+
+func (r *prettyReporter) paint(color, s string) string {
+	if !r.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// Report prints a single response with its request, status, timing and body
+func (r *prettyReporter) Report(_ int, resp Response) error {
+	r.total++
+	if resp.Failed(r.failOn) {
+		r.failed++
+	}
+
+	fmt.Fprintln(r.w, r.paint(colorBold, "### "+resp.Name))
+	fmt.Fprintf(r.w, "%s %s\n", r.paint(colorBold, resp.Method), resp.URL)
+
+	switch {
+	case resp.Skipped != "":
+		fmt.Fprintln(r.w, r.paint(colorYellow, "skipped: "+resp.Skipped))
+	case resp.Error != nil:
+		fmt.Fprintln(r.w, r.paint(colorRed, "error: "+resp.Error.Error()))
+	default:
+		duration := resp.Duration
+		if resp.Timings != nil {
+			duration = resp.Timings.Total
+		}
+		status := statusLine(resp.ReturnCode)
+		details := fmt.Sprintf(" (%s, %s, %s)", resp.HTTPVersion, FormatDuration(duration), formatSize(resp.BodySize))
+		fmt.Fprintln(r.w, r.paint(statusColor(resp.ReturnCode), status)+r.paint(colorDim, details))
+	}
+
+	if len(resp.Attempts) > 0 {
+		fmt.Fprintln(r.w, r.paint(colorDim, fmt.Sprintf("%d attempts", len(resp.Attempts))))
+	}
+	if resp.Polls > 1 {
+		fmt.Fprintln(r.w, r.paint(colorDim, fmt.Sprintf("%d polls", resp.Polls)))
+	}
+	if resp.SavedTo != "" {
+		fmt.Fprintln(r.w, r.paint(colorDim, "saved to "+resp.SavedTo))
+	}
+	for _, d := range resp.Differences {
+		fmt.Fprintln(r.w, r.paint(colorYellow, fmt.Sprintf("%s %s compared to %s", d.Path, d.Kind, resp.ComparedTo)))
+	}
+
+	r.renderBody(resp)
+	_, err := fmt.Fprintln(r.w)
+	return err
+}
+
+// renderBody pretty prints JSON bodies with syntax highlighting and text bodies as they are
+func (r *prettyReporter) renderBody(resp Response) {
+	switch {
+	case resp.Encoding == EncodingBase64:
+		fmt.Fprintln(r.w, r.paint(colorDim, fmt.Sprintf("<binary body, %s>", formatSize(int64(len(resp.Body))))))
+	case len(resp.Content) > 0 && resp.Content[0] != '"':
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, bytes.TrimSpace(resp.Content), "", "  "); err != nil {
+			fmt.Fprintln(r.w, string(resp.Body))
+			return
+		}
+		fmt.Fprintln(r.w, r.highlightJSON(indented.Bytes()))
+	case len(resp.Body) > 0:
+		fmt.Fprintln(r.w, strings.TrimRight(string(resp.Body), "\n"))
+	}
+}
+
+// highlightJSON colours the keys, strings, numbers and literals of a JSON document
+func (r *prettyReporter) highlightJSON(src []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(src); {
+		j := i + 1
+		switch c := src[i]; {
+		case c == '"':
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(src) {
+				j++
+			}
+			color := colorGreen
+			if rest := bytes.TrimLeft(src[j:], " "); len(rest) > 0 && rest[0] == ':' {
+				color = colorBlue
+			}
+			b.WriteString(r.paint(color, string(src[i:j])))
+		case c == '-' || ('0' <= c && c <= '9'):
+			for j < len(src) && strings.IndexByte("+-.0123456789eE", src[j]) >= 0 {
+				j++
+			}
+			b.WriteString(r.paint(colorCyan, string(src[i:j])))
+		case 'a' <= c && c <= 'z':
+			for j < len(src) && 'a' <= src[j] && src[j] <= 'z' {
+				j++
+			}
+			b.WriteString(r.paint(colorMagenta, string(src[i:j])))
+		default:
+			b.WriteByte(c)
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// Finish prints the number of requests and how many of them failed
+func (r *prettyReporter) Finish([]Response) error {
+	line := fmt.Sprintf("%d requests, %d failed", r.total, r.failed)
+	color := colorGreen
+	if r.failed > 0 {
+		color = colorRed
+	}
+	_, err := fmt.Fprintln(r.w, r.paint(color, line))
+	return err
+}
+
+func statusColor(code int) string {
+	switch {
+	case code >= 500:
+		return colorRed
+	case code >= 400:
+		return colorYellow
+	case code >= 300:
+		return colorCyan
+	}
+	return colorGreen
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+}
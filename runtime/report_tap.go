@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tapReporter writes the responses in the Test Anything Protocol version 13 once the run finished
+type tapReporter struct {
+	w      io.Writer
+	failOn StatusSet
+}
+
+func (r *tapReporter) Report(int, Response) error {
+	return nil
+}
+
+func (r *tapReporter) Finish(responses []Response) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TAP version 13\n1..%d\n", len(responses))
+	for i, resp := range responses {
+		description := fmt.Sprintf("%s %s %s", resp.Name, resp.Method, resp.URL)
+		switch {
+		case resp.Skipped != "":
+			fmt.Fprintf(&b, "ok %d - %s # SKIP %s\n", i+1, description, resp.Skipped)
+		case resp.Failed(r.failOn):
+			fmt.Fprintf(&b, "not ok %d - %s\n", i+1, description)
+			fmt.Fprintf(&b, "  ---\n  message: %q\n", summary(resp))
+			if resp.ReturnCode > 0 {
+				fmt.Fprintf(&b, "  status: %d\n", resp.ReturnCode)
+			}
+			for _, d := range resp.Differences {
+				fmt.Fprintf(&b, "  # %s %s\n", d.Path, d.Kind)
+			}
+			b.WriteString("  ...\n")
+		default:
+			fmt.Fprintf(&b, "ok %d - %s\n", i+1, description)
+		}
+	}
+
+	_, err := io.WriteString(r.w, b.String())
+	return err
+}
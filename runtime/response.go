@@ -1,28 +1,151 @@
 package runtime
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
 
-// MaybeJSON allows marshaling of text that my be json or not
-// returns a string if it is not
-type MaybeJSON []byte
+	"intelirest-cli/parser"
+)
 
-// MarshallJSON Implements the Marshaling interface of JSON
-func (m MaybeJSON) MarshalJSON() ([]byte, error) {
-	if len(m) == 0 {
-		return nil, nil
+// Response is the struct which client populates from the answers from the Rest calls.
+type Response struct {
+	// Name, Method and URL identify the request. URL is the URL of the final request after redirects.
+	Name        string
+	Method      string
+	URL         string
+	Skipped     string `json:",omitempty"`
+	Error       *Error `json:",omitempty"`
+	HTTPVersion string
+	ReturnCode  int
+	Header      http.Header
+	Trailer     http.Header `json:",omitempty"`
+	Cookies     []Cookie    `json:",omitempty"`
+	// BodySize is the number of bytes of the body received, after decompression
+	BodySize int64
+	// Duration is the time from sending the request until the response was received
+	Duration time.Duration
+	Timings  *Timings `json:",omitempty"`
+	// Body is the body of the response, converted to UTF-8 for text bodies
+	Body []byte `json:"-"`
+	// Content is the body as it appears in the output: JSON bodies as JSON, text bodies as string
+	// and binary bodies as base64 encoded string with an Encoding of base64
+	Content   json.RawMessage `json:",omitempty"`
+	Encoding  string          `json:",omitempty"`
+	Redirects []Redirect      `json:",omitempty"`
+	Attempts  []Attempt       `json:",omitempty"`
+	Polls     int             `json:",omitempty"`
+	// Reauthenticated is set if the request was repeated after a 401 response re-ran the auth provider
+	Reauthenticated bool         `json:",omitempty"`
+	SavedTo         string       `json:",omitempty"`
+	ComparedTo      string       `json:",omitempty"`
+	Differences     []Difference `json:",omitempty"`
+}
+
+// Cookie is a cookie set by a response with a Set-Cookie header
+type Cookie struct {
+	Name     string
+	Value    string
+	Domain   string     `json:",omitempty"`
+	Path     string     `json:",omitempty"`
+	Expires  *time.Time `json:",omitempty"`
+	MaxAge   int        `json:",omitempty"`
+	Secure   bool       `json:",omitempty"`
+	HttpOnly bool       `json:",omitempty"`
+	SameSite string     `json:",omitempty"`
+}
+
+func newCookies(cookies []*http.Cookie) []Cookie {
+	if len(cookies) == 0 {
+		return nil
 	}
 
-	if m[0] == '[' || m[0] == '{' || m[0] == '"' {
-		return json.Marshal(json.RawMessage(m))
+	out := make([]Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		cookie := Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			MaxAge:   c.MaxAge,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		}
+		if !c.Expires.IsZero() {
+			expires := c.Expires.UTC()
+			cookie.Expires = &expires
+		}
+		switch c.SameSite {
+		case http.SameSiteLaxMode:
+			cookie.SameSite = "Lax"
+		case http.SameSiteStrictMode:
+			cookie.SameSite = "Strict"
+		case http.SameSiteNoneMode:
+			cookie.SameSite = "None"
+		}
+		out = append(out, cookie)
 	}
+	return out
+}
 
-	return json.Marshal(string(m))
+// identify sets the identity of the request the response belongs to. The URL of a response
+// which was received is kept as it is the URL after redirects.
+func (r *Response) identify(req parser.Request) {
+	r.Name = req.Name
+	r.Method = req.Operation.Method()
+	if r.URL == "" {
+		r.URL = requestURL(req)
+	}
 }
 
-// Response is the struct which client populates from the answers from the Rest calls.
-type Response struct {
+// LegacyResponse is the format responses were printed in before they carried the identity of their
+// request, multi-valued headers, cookies, sizes and durations
+type LegacyResponse struct {
+	Skipped     string `json:",omitempty"`
+	Error       *Error `json:",omitempty"`
 	HTTPVersion string
 	ReturnCode  int
 	Header      map[string]string
-	Content     MaybeJSON
+	Content     json.RawMessage `json:",omitempty"`
+	Encoding    string          `json:",omitempty"`
+	Redirects   []Redirect      `json:",omitempty"`
+	Attempts    []Attempt       `json:",omitempty"`
+	Polls       int             `json:",omitempty"`
+	SavedTo     string          `json:",omitempty"`
+	ComparedTo  string          `json:",omitempty"`
+	Differences []Difference    `json:",omitempty"`
+}
+
+// Legacy converts the response to the legacy format, joining multiple values of a header with QueryJoinCharacter
+func (r *Response) Legacy() LegacyResponse {
+	var header map[string]string
+	if r.Header != nil {
+		header = make(map[string]string, len(r.Header))
+		for key, values := range r.Header {
+			header[key] = strings.Join(values, QueryJoinCharacter)
+		}
+	}
+
+	return LegacyResponse{
+		Skipped:     r.Skipped,
+		Error:       r.Error,
+		HTTPVersion: r.HTTPVersion,
+		ReturnCode:  r.ReturnCode,
+		Header:      header,
+		Content:     r.Content,
+		Encoding:    r.Encoding,
+		Redirects:   r.Redirects,
+		Attempts:    r.Attempts,
+		Polls:       r.Polls,
+		SavedTo:     r.SavedTo,
+		ComparedTo:  r.ComparedTo,
+		Differences: r.Differences,
+	}
+}
+
+// Failed reports whether the request did not succeed. This is the case if it was skipped, could not be
+// executed, got one of the status codes of failOn or its body differs from the previous response.
+func (r *Response) Failed(failOn StatusSet) bool {
+	return r.Skipped != "" || r.Error != nil || failOn.Contains(r.ReturnCode) || len(r.Differences) > 0
 }
@@ -0,0 +1,193 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"intelirest-cli/parser"
+)
+
+// Backoff decides how the delay between retries grows
+type Backoff string
+
+const (
+	// BackoffConstant waits the same delay before every retry
+	BackoffConstant Backoff = "constant"
+	// BackoffLinear waits the delay times the number of the retry
+	BackoffLinear Backoff = "linear"
+	// BackoffExponential doubles the delay with every retry
+	BackoffExponential Backoff = "exponential"
+)
+
+// RetryOn decides which failed attempts are retried
+type RetryOn struct {
+	Statuses StatusSet
+	Kinds    []ErrorKind
+}
+
+// DefaultRetryOn retries on unavailable servers and connections which could not be established
+var DefaultRetryOn = RetryOn{
+	Statuses: StatusSet{{429, 429}, {502, 504}},
+	Kinds:    []ErrorKind{ErrorKindConnect, ErrorKindConnectTimeout},
+}
+
+// ParseRetryOn parses status codes in the forms accepted by ParseStatusSet and error kinds like connect or timeout
+func ParseRetryOn(specs []string) (RetryOn, error) {
+	var on RetryOn
+	for _, spec := range specs {
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		switch kind := ErrorKind(spec); kind {
+		case ErrorKindDNS, ErrorKindConnect, ErrorKindTLS, ErrorKindConnectTimeout, ErrorKindTimeout, ErrorKindOther:
+			on.Kinds = append(on.Kinds, kind)
+			continue
+		}
+
+		statuses, err := ParseStatusSet([]string{spec})
+		if err != nil {
+			return on, fmt.Errorf("invalid retry condition \"%s\", expected a status code or error kind", spec)
+		}
+		on.Statuses = append(on.Statuses, statuses...)
+	}
+	return on, nil
+}
+
+// RetryPolicy decides how often and when failed attempts of a request are repeated
+type RetryPolicy struct {
+	Retries int
+	Backoff Backoff
+	Delay   time.Duration
+	// Max limits the delay between two attempts, including delays requested with Retry-After. Zero means no limit.
+	Max time.Duration
+	On  RetryOn
+}
+
+// DefaultRetryPolicy does not retry. Once retries are enabled the delay starts at one second and doubles
+// up to 30 seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	Backoff: BackoffExponential,
+	Delay:   time.Second,
+	Max:     30 * time.Second,
+	On:      DefaultRetryOn,
+}
+
+// Attempt is a single try of a request which was retried
+type Attempt struct {
+	ReturnCode int    `json:",omitempty"`
+	Error      *Error `json:",omitempty"`
+	Duration   time.Duration
+	// Delay is the time waited before the next attempt
+	Delay time.Duration `json:",omitempty"`
+}
+
+// retryPolicy returns the retry policy of the client with the settings of the retry directive applied.
+// Requests which are not idempotent, like POST requests, are only retried if they have a retry directive.
+func (c *Client) retryPolicy(req parser.Request) (RetryPolicy, error) {
+	policy := c.retry
+	opt, ok := req.Option(parser.OptionRetry)
+	if !ok {
+		if !idempotent(req.Operation) {
+			policy.Retries = 0
+		}
+		return policy, nil
+	}
+
+	retry, err := opt.Retry()
+	if err != nil {
+		return policy, err
+	}
+	policy.Retries = retry.Retries
+	if retry.Backoff != "" {
+		policy.Backoff = Backoff(retry.Backoff)
+	}
+	if retry.Delay > 0 {
+		policy.Delay = retry.Delay
+	}
+	if retry.Max > 0 {
+		policy.Max = retry.Max
+	}
+	return policy, nil
+}
+
+// idempotent reports whether sending a request of the operation several times has the same effect as
+// sending it once
+func idempotent(op parser.Operation) bool {
+	switch op {
+	case parser.OperationPOST, parser.OperationPATCH:
+		return false
+	}
+	return true
+}
+
+// shouldRetry reports whether an attempt ending with resp or err is retried
+func (p RetryPolicy) shouldRetry(resp *Response, err error) bool {
+	if err == nil {
+		return p.On.Statuses.Contains(resp.ReturnCode)
+	}
+
+	kind := NewError(err).Kind
+	for _, k := range p.On.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the time to wait after the given attempt, counted from zero. A Retry-After header
+// of the response extends the delay.
+func (p RetryPolicy) delay(attempt int, resp *Response, now time.Time) time.Duration {
+	d := p.Delay
+	switch p.Backoff {
+	case BackoffLinear:
+		d *= time.Duration(attempt + 1)
+	case BackoffExponential:
+		for i := 0; i < attempt && (p.Max <= 0 || d < p.Max); i++ {
+			d *= 2
+		}
+	}
+
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After"), now); ok && after > d {
+			d = after
+		}
+	}
+
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// retryAfter parses the value of a Retry-After header given in seconds or as HTTP date
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sleep waits for d and reports whether ctx is still running afterwards
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -1,12 +1,16 @@
 package runtime
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
 	"os"
-	"strings"
+	"sync"
+	"time"
 
 	"intelirest-cli/parser"
 
@@ -19,9 +23,26 @@ const DefaultMaxSimultaneousConnections = 4
 var QueryJoinCharacter = ", "
 
 type Client struct {
-	client  *resty.Client
-	maxconn int
-	verbose bool
+	client         *resty.Client
+	noJarClient    *resty.Client
+	jar            *CookieJar
+	maxconn        int
+	maxRedirects   int
+	logger         *Logger
+	parallel       bool
+	errorMode      ErrorMode
+	timeout        time.Duration
+	connectTimeout time.Duration
+	retry          RetryPolicy
+	progress       io.Writer
+	redactor       *Redactor
+	tokens         *TokenManager
+	globals        globals
+	auth           reauthenticator
+	callback       func(i int, resp Response)
+	failOn         StatusSet
+	compare        bool
+	ignore         []string
 }
 
 func New(maxSimulataneousConnections int) *Client {
@@ -29,25 +50,232 @@ func New(maxSimulataneousConnections int) *Client {
 		maxSimulataneousConnections = DefaultMaxSimultaneousConnections
 	}
 
+	transport := &digestTransport{next: &wireLogTransport{next: newTransport()}}
+	jar := NewCookieJar()
+
 	return &Client{
-		client:  resty.New(),
-		maxconn: maxSimulataneousConnections,
+		client:       newRestyClient(transport, jar),
+		noJarClient:  newRestyClient(transport, nil),
+		jar:          jar,
+		maxconn:      maxSimulataneousConnections,
+		maxRedirects: DefaultMaxRedirects,
+		retry:        DefaultRetryPolicy,
 	}
 }
 
+// SetVerbose logs each request to stderr.
+//
+// Deprecated: use SetLogger to choose the level and destination of the log.
 func (c *Client) SetVerbose() {
-	c.verbose = true
+	c.logger = NewLogger(os.Stderr, LogRequests)
 }
 
-func (c *Client) Do(requests []parser.Request) ([]Response, error) {
-	rErr := &multierror.Error{}
-	responses := make([]Response, 0)
-	for i, request := range requests {
-		resp, err := c.ExecuteRequest(request)
+// SetLogger sets the logger requests and responses are logged to. Requests with the no-log directive are not logged.
+func (c *Client) SetLogger(logger *Logger) {
+	c.logger = logger
+}
+
+// SetParallel lets Do run independent requests concurrently on up to maxconn connections
+func (c *Client) SetParallel() {
+	c.parallel = true
+}
+
+// SetErrorMode decides how Do continues after a request failed
+func (c *Client) SetErrorMode(mode ErrorMode) {
+	c.errorMode = mode
+}
+
+// SetFailOnStatus sets the status codes failing a request, which makes Do skip the requests depending on
+// it or, in ErrorModeFailFast, all remaining requests. By default no status code fails a request.
+func (c *Client) SetFailOnStatus(failOn StatusSet) {
+	c.failOn = failOn
+}
+
+// SetTimeout sets the time a request may take. Zero disables the timeout.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetConnectTimeout sets the time establishing a connection may take. Zero keeps the default of 30 seconds.
+func (c *Client) SetConnectTimeout(timeout time.Duration) {
+	c.connectTimeout = timeout
+}
+
+// SetRetryPolicy sets how failed attempts of requests without retry directive are repeated
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
+// SetProgress sets the writer progress of long running requests, like polls, is reported to
+func (c *Client) SetProgress(w io.Writer) {
+	c.progress = w
+}
+
+// SetTokenManager sets the token manager resolving {{$auth.token("id")}} references of requests
+func (c *Client) SetTokenManager(tokens *TokenManager) {
+	c.tokens = tokens
+}
+
+// SetRedactor redacts secrets from the progress of long running requests. The tokens obtained for requests
+// are added to redactor, so they are redacted wherever it is used.
+func (c *Client) SetRedactor(redactor *Redactor) {
+	c.redactor = redactor
+}
+
+// SetResponseCallback sets a function Do calls with the index and the response of each request as soon
+// as the request completed or was skipped. Calls are serialized, in parallel mode they happen in the
+// order the requests complete.
+func (c *Client) SetResponseCallback(callback func(i int, resp Response)) {
+	c.callback = callback
+}
+
+// SetCookieJar replaces the cookie jar shared by all requests of the client
+func (c *Client) SetCookieJar(jar *CookieJar) {
+	c.jar = jar
+	c.client.SetCookieJar(jar)
+}
+
+// CookieJar returns the cookie jar shared by all requests of the client
+func (c *Client) CookieJar() *CookieJar {
+	return c.jar
+}
+
+// SetCompare enables comparing responses to the previous responses referenced with `<> path`.
+// Differences at or below the ignored JSON paths are accepted.
+func (c *Client) SetCompare(ignore []string) {
+	c.compare = true
+	c.ignore = ignore
+}
+
+// SetMaxRedirects sets the number of redirects a request may follow before it fails
+func (c *Client) SetMaxRedirects(max int) {
+	c.maxRedirects = max
+}
+
+// Do executes the requests and returns their responses in the order of the requests.
+// Requests wait for the requests they depend on. What happens after a request failed depends on
+// the ErrorMode of the client. Requests which could not be executed carry an Error in their response.
+// In parallel mode requests run concurrently unless they depend on the results of earlier requests.
+// Once ctx is done running requests are cancelled and the remaining requests skipped.
+// Each response is passed to the response callback of the client as soon as it is known.
+func (c *Client) Do(ctx context.Context, requests []parser.Request) ([]Response, error) {
+	return c.do(ctx, requests, c.callback)
+}
+
+// Result is a response sent by Stream together with the index of its request. The last result of a
+// failed run has an Index of -1 and carries the error Do would return instead of a response.
+type Result struct {
+	Index    int
+	Response Response
+	Err      error
+}
+
+// Stream executes the requests like Do and sends each response on the returned channel as soon as
+// its request completed or was skipped, followed by the error of the run if it failed. The channel is
+// closed once all requests are done. Its buffer holds all results, so a slow receiver never holds up
+// the requests.
+func (c *Client) Stream(ctx context.Context, requests []parser.Request) <-chan Result {
+	results := make(chan Result, len(requests)+1)
+	go func() {
+		defer close(results)
+		_, err := c.do(ctx, requests, func(i int, resp Response) {
+			if c.callback != nil {
+				c.callback(i, resp)
+			}
+			results <- Result{Index: i, Response: resp}
+		})
 		if err != nil {
-			rErr = multierror.Append(fmt.Errorf("error executing request %d: %e", i, err))
+			results <- Result{Index: -1, Err: err}
+		}
+	}()
+	return results
+}
+
+func (c *Client) do(ctx context.Context, requests []parser.Request, callback func(i int, resp Response)) ([]Response, error) {
+	responses := make([]Response, len(requests))
+	for _, req := range requests {
+		if req.HasOption(parser.OptionAuthProvider) {
+			c.SetAuthProvider(req)
+		}
+	}
+
+	workers := 1
+	if c.parallel {
+		workers = c.maxconn
+	}
+
+	var mu, callbackMu sync.Mutex
+	abortedBy := -1
+
+	schedule(len(requests), workers, dependencies(requests, c.parallel), func(i int) {
+		defer func() {
+			responses[i].identify(requests[i])
+			if callback != nil {
+				callbackMu.Lock()
+				defer callbackMu.Unlock()
+				callback(i, responses[i])
+			}
+		}()
+
+		mu.Lock()
+		aborted := abortedBy
+		mu.Unlock()
+		if aborted >= 0 {
+			responses[i].Skipped = fmt.Sprintf("run aborted after request \"%s\" failed", requests[aborted].Name)
+			return
+		}
+
+		switch ctx.Err() {
+		case context.Canceled:
+			responses[i].Skipped = "run interrupted"
+			return
+		case context.DeadlineExceeded:
+			responses[i].Skipped = "run deadline exceeded"
+			return
+		}
+
+		if c.errorMode != ErrorModeContinue {
+			for _, dep := range requests[i].DependsOn {
+				if responses[dep].Failed(c.failOn) {
+					reason := "failed"
+					if responses[dep].Skipped != "" {
+						reason = "was skipped"
+					}
+					responses[i].Skipped = fmt.Sprintf("prerequisite \"%s\" %s", requests[dep].Name, reason)
+					return
+				}
+			}
+		}
+
+		resp, err := c.ExecuteRequest(ctx, requests[i])
+		if resp != nil {
+			responses[i] = *resp
+		}
+		if err != nil {
+			responses[i].Error = NewError(err)
+		}
+
+		if c.errorMode == ErrorModeFailFast && responses[i].Failed(c.failOn) {
+			mu.Lock()
+			if abortedBy < 0 {
+				abortedBy = i
+			}
+			mu.Unlock()
+		}
+	})
+
+	rErr := &multierror.Error{}
+	for i, resp := range responses {
+		switch {
+		case resp.Error != nil:
+			rErr = multierror.Append(rErr, fmt.Errorf("error executing request %d: %w", i, resp.Error))
+		case resp.Skipped != "":
+			rErr = multierror.Append(rErr, fmt.Errorf("skipped request %d: %s", i, resp.Skipped))
+		case len(resp.Differences) > 0:
+			rErr = multierror.Append(rErr, fmt.Errorf("response of request %d differs from %s", i, resp.ComparedTo))
+		case c.failOn.Contains(resp.ReturnCode):
+			rErr = multierror.Append(rErr, fmt.Errorf("request %d got the failing status %d", i, resp.ReturnCode))
 		}
-		responses = append(responses, *resp)
 	}
 	if rErr.Len() == 0 {
 		return responses, nil
@@ -56,16 +284,206 @@ func (c *Client) Do(requests []parser.Request) ([]Response, error) {
 	return responses, rErr
 }
 
-func (c *Client) ExecuteRequest(req parser.Request) (*Response, error) {
-	if c.verbose {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		if err := enc.Encode(req); err != nil {
+// ExecuteRequest sends a single request. The request is cancelled once ctx is done.
+// Failed attempts are repeated according to the retry policy of the client and the retry directive
+// of the request and requests with poll-until directive are repeated until their condition holds.
+// If the request failed after getting a response, like a retried request or a poll which timed out,
+// the returned Response carries the attempts and polls made.
+func (c *Client) ExecuteRequest(ctx context.Context, req parser.Request) (*Response, error) {
+	if req.ResponseHandlerFile != "" {
+		if _, err := os.Stat(req.Path(req.ResponseHandlerFile)); err != nil {
+			return nil, &Error{Kind: ErrorKindScript, Message: err.Error()}
+		}
+	}
+
+	policy, err := c.retryPolicy(req)
+	if err != nil {
+		return nil, err
+	}
+
+	savedTo := ""
+	if req.Output != nil {
+		// Determined once so retries and polls overwrite the body of previous attempts. The path may use the
+		// variables set by the auth provider and is relative to the file of the request.
+		output := *req.Output
+		output.Path = req.Path(c.globals.replace(output.Path))
+		if savedTo, err = outputPath(&output); err != nil {
 			return nil, err
 		}
 	}
-	restReq := c.client.R()
-	restReq.SetHeaders(req.Headers)
+
+	send := func() (*Response, error) {
+		generation := c.auth.current()
+		resp, err := c.send(ctx, req, policy, savedTo)
+		if err == nil && resp.ReturnCode == http.StatusUnauthorized && c.reauthenticate(ctx, req, generation) {
+			resp, err = c.send(ctx, req, policy, savedTo)
+			if resp != nil {
+				resp.Reauthenticated = true
+			}
+		}
+		return resp, err
+	}
+	resp, err := send()
+	if err == nil && req.HasOption(parser.OptionPollUntil) {
+		resp, err = c.poll(ctx, req, resp, send)
+	}
+	if resp != nil {
+		resp.identify(req)
+	}
+	if err != nil {
+		return resp, err
+	}
+
+	if req.HasOption(parser.OptionAuthProvider) {
+		if err := c.runHandler(req, resp); err != nil {
+			return resp, err
+		}
+	}
+
+	if c.compare && len(req.ResponseReferences) > 0 {
+		// The first reference is the most recent previous response
+		resp.ComparedTo = req.ResponseReferences[0]
+		body, err := responseBody(resp)
+		if err == nil {
+			resp.Differences, err = CompareFile(resp.ComparedTo, body, c.ignore)
+		}
+		if err != nil {
+			// The response was received, only comparing it failed
+			return resp, &Error{Kind: ErrorKindFile, Message: err.Error()}
+		}
+	}
+
+	return resp, nil
+}
+
+// send sends the request and repeats failed attempts according to the retry policy
+func (c *Client) send(ctx context.Context, req parser.Request, policy RetryPolicy, savedTo string) (*Response, error) {
+	attempts := make([]Attempt, 0)
+	var resp *Response
+	var err error
+	var duration time.Duration
+	for i := 0; ; i++ {
+		start := time.Now()
+		resp, err = c.attempt(ctx, req, savedTo)
+		duration = time.Since(start)
+		if i == policy.Retries || ctx.Err() != nil || !policy.shouldRetry(resp, err) {
+			break
+		}
+
+		attempt := Attempt{Duration: duration}
+		if err != nil {
+			attempt.Error = NewError(err)
+		} else {
+			attempt.ReturnCode = resp.ReturnCode
+		}
+		attempt.Delay = policy.delay(i, resp, time.Now())
+		attempts = append(attempts, attempt)
+		c.loggerFor(req).Logf(LogRequests, "%s: retrying in %s", req.Name, FormatDuration(attempt.Delay))
+
+		if !sleep(ctx, attempt.Delay) {
+			break
+		}
+	}
+
+	if len(attempts) > 0 {
+		last := Attempt{Duration: duration}
+		if err != nil {
+			last.Error = NewError(err)
+		} else {
+			last.ReturnCode = resp.ReturnCode
+		}
+		attempts = append(attempts, last)
+	}
+	if err != nil {
+		if len(attempts) > 0 {
+			return &Response{Attempts: attempts}, err
+		}
+		return nil, err
+	}
+	resp.Attempts = attempts
+
+	return resp, nil
+}
+
+// attempt sends the request once
+func (c *Client) attempt(ctx context.Context, req parser.Request, savedTo string) (*Response, error) {
+	// Variables and tokens are resolved for every attempt so retries use the variables set by
+	// re-authentication and refreshed tokens
+	req, err := substitute(req, c.globals.replace)
+	if err != nil {
+		return nil, err
+	}
+	if req, err = c.resolveAuth(ctx, req); err != nil {
+		return nil, err
+	}
+	reqCtx, cancel, err := c.requestContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	logger := c.loggerFor(req)
+	logger.Logf(LogRequests, "> %s: %s %s", req.Name, req.Operation.Method(), requestURL(req))
+	if logger.Enabled(LogHeaders) {
+		header := make(http.Header, len(req.Headers))
+		for key, value := range requestHeaders(req) {
+			header.Set(key, value)
+		}
+		body := req.Body
+		if req.FileLoad != "" {
+			body = "<body from " + req.FileLoad + ">"
+		}
+		logger.Logf(LogHeaders, "> %s: headers and body\n%s\n\n%s", req.Name, formatHeader(header), body)
+	}
+	if logger.Enabled(LogWire) {
+		reqCtx = withWireLogger(reqCtx, logger)
+	}
+	if creds, ok := requestDigestAuth(req); ok {
+		reqCtx = withDigestAuth(reqCtx, creds)
+	}
+
+	rec := newRedirectRecorder(!req.HasOption(parser.OptionDoNotFollowRedirect), c.maxRedirects)
+	timing := newTimingRecorder()
+	reqCtx = httptrace.WithClientTrace(withRedirectRecorder(reqCtx, rec), timing.trace())
+	restReq := c.clientFor(req).R().SetContext(reqCtx)
+	if savedTo != "" {
+		// resty streams the body into the file instead of keeping it in memory
+		restReq.SetOutput(savedTo)
+	}
+
+	resp, err := c.execute(req, restReq)
+	if err != nil {
+		logger.Logf(LogRequests, "< %s: %s", req.Name, err)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// The run deadline passed rather than the timeout of the request
+			return nil, &Error{Kind: ErrorKindDeadline, Message: err.Error()}
+		}
+		return nil, err
+	}
+	resp.Redirects = rec.hops
+	resp.SavedTo = savedTo
+	resp.Timings = timing.finish()
+
+	logger.Logf(LogRequests, "< %s: %s (%s)", req.Name, statusLine(resp.ReturnCode), FormatDuration(resp.Timings.Total))
+	if logger.Enabled(LogHeaders) {
+		logger.Logf(LogHeaders, "< %s: headers and body\n%s\n\n%s", req.Name, formatHeader(resp.Header),
+			formatBody(resp.Body, resp.Encoding == EncodingBase64))
+	}
+
+	return resp, nil
+}
+
+// loggerFor returns the logger of the client or nil if the request is excluded from the log
+func (c *Client) loggerFor(req parser.Request) *Logger {
+	if req.HasOption(parser.OptionNoLog) {
+		return nil
+	}
+	return c.logger
+}
+
+func (c *Client) execute(req parser.Request, restReq *resty.Request) (*Response, error) {
+	reqURL := requestURL(req)
+	restReq.SetHeaders(requestHeaders(req))
 	//for key, vals := range req.URL.Query() {
 	//	restReq.SetQueryParam(key, strings.Join(vals, QueryJoinCharacter))
 	//}
@@ -78,7 +496,7 @@ func (c *Client) ExecuteRequest(req parser.Request) (*Response, error) {
 
 	switch req.Operation {
 	case parser.OperationGET:
-		resp, err := restReq.Get(req.URL.String())
+		resp, err := restReq.Get(reqURL)
 		if err != nil {
 			return nil, err
 		}
@@ -87,7 +505,7 @@ func (c *Client) ExecuteRequest(req parser.Request) (*Response, error) {
 		var body []byte
 		if req.FileLoad != "" {
 			var err error
-			body, err = ioutil.ReadFile(req.FileLoad)
+			body, err = ioutil.ReadFile(req.Path(req.FileLoad))
 			if err != nil {
 				return nil, err
 			}
@@ -98,22 +516,22 @@ func (c *Client) ExecuteRequest(req parser.Request) (*Response, error) {
 		var resp *resty.Response
 		var err error
 		if req.Operation == parser.OperationPATCH {
-			resp, err = restReq.SetBody(body).Patch(req.URL.String())
+			resp, err = restReq.SetBody(body).Patch(reqURL)
 		} else {
-			resp, err = restReq.SetBody(body).Post(req.URL.String())
+			resp, err = restReq.SetBody(body).Post(reqURL)
 		}
 		if err != nil {
 			return nil, err
 		}
 		return respFromResty(resp)
 	case parser.OperationDELETE:
-		resp, err := restReq.Delete(req.URL.String())
+		resp, err := restReq.Delete(reqURL)
 		if err != nil {
 			return nil, err
 		}
 		return respFromResty(resp)
 	case parser.OperationHEAD:
-		resp, err := restReq.Head(req.URL.String())
+		resp, err := restReq.Head(reqURL)
 		if err != nil {
 			return nil, err
 		}
@@ -124,23 +542,21 @@ func (c *Client) ExecuteRequest(req parser.Request) (*Response, error) {
 }
 
 func respFromResty(restyResp *resty.Response) (*Response, error) {
+	raw := restyResp.RawResponse
 	resp := &Response{
-		Header: make(map[string]string),
-	}
-	for key, value := range restyResp.Header() {
-		resp.Header[key] = strings.Join(value, QueryJoinCharacter)
+		URL:         raw.Request.URL.String(),
+		HTTPVersion: raw.Proto,
+		ReturnCode:  restyResp.StatusCode(),
+		Header:      restyResp.Header(),
+		Cookies:     newCookies(restyResp.Cookies()),
+		BodySize:    restyResp.Size(),
+		Duration:    restyResp.Time(),
 	}
-
-	body := restyResp.Body()
-	if decoded, err := base64.StdEncoding.DecodeString(string(body)); err == nil {
-		resp.Content = decoded
-	} else {
-		resp.Content = body
+	if len(raw.Trailer) > 0 {
+		resp.Trailer = raw.Trailer
 	}
 
-	resp.HTTPVersion = restyResp.RawResponse.Proto
-
-	resp.ReturnCode = restyResp.StatusCode()
+	resp.setBody(restyResp.Header().Get("Content-Type"), restyResp.Body())
 
 	return resp, nil
 }
@@ -0,0 +1,2075 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"intelirest-cli/parser"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseRequests(t *testing.T, input string, env map[string]string) []parser.Request {
+	p, err := parser.NewReader(bytes.NewBufferString(input), env)
+	assert.NoError(t, err)
+	requests, err := p.Parse()
+	assert.NoError(t, err)
+	return requests
+}
+
+func TestRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/end", http.StatusFound)
+	})
+	mux.HandleFunc("/end", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("done."))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Follow redirects
+GET {{host}}/start
+
+### Do not follow redirects
+# @no-redirect
+GET {{host}}/start
+`, map[string]string{"host": srv.URL})
+
+	client := New(1)
+	responses, err := client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Len(t, responses, 2)
+
+	assert.Equal(t, http.StatusOK, responses[0].ReturnCode)
+	assert.Equal(t, "done.", string(responses[0].Body))
+	if assert.Len(t, responses[0].Redirects, 2) {
+		assert.Equal(t, srv.URL+"/start", responses[0].Redirects[0].URL)
+		assert.Equal(t, http.StatusMovedPermanently, responses[0].Redirects[0].ReturnCode)
+		assert.Equal(t, "/middle", responses[0].Redirects[0].Location)
+		assert.Equal(t, http.StatusFound, responses[0].Redirects[1].ReturnCode)
+		assert.Equal(t, "/end", responses[0].Redirects[1].Location)
+	}
+
+	assert.Equal(t, http.StatusMovedPermanently, responses[1].ReturnCode)
+	assert.Empty(t, responses[1].Redirects)
+
+	client.SetMaxRedirects(1)
+	_, err = client.ExecuteRequest(context.Background(), requests[0])
+	assert.Error(t, err)
+}
+
+func TestDirectives(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	mux.HandleFunc("/set", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+	})
+	mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.RawQuery))
+	})
+	mux.HandleFunc("/cookie", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Slow
+# @timeout 50ms
+GET {{host}}/slow
+
+### Login
+GET {{host}}/set
+
+### Without cookies
+# @no-cookie-jar
+GET {{host}}/cookie
+
+### With cookies
+GET {{host}}/cookie
+
+### Encoded query
+GET {{host}}/query?name=J"o|n
+
+### Raw query
+# @no-auto-encoding
+GET {{host}}/query?name=J"o|n
+`, map[string]string{"host": srv.URL})
+
+	client := New(1)
+	_, err := client.ExecuteRequest(context.Background(), requests[0])
+	assert.Error(t, err)
+
+	for i, code := range []int{http.StatusOK, http.StatusUnauthorized, http.StatusOK} {
+		resp, err := client.ExecuteRequest(context.Background(), requests[i+1])
+		assert.NoError(t, err)
+		assert.Equal(t, code, resp.ReturnCode, requests[i+1].Name)
+	}
+
+	resp, err := client.ExecuteRequest(context.Background(), requests[4])
+	assert.NoError(t, err)
+	assert.Equal(t, "name=J%22o%7Cn", string(resp.Body))
+
+	resp, err = client.ExecuteRequest(context.Background(), requests[5])
+	assert.NoError(t, err)
+	assert.Equal(t, `name=J"o|n`, string(resp.Body))
+}
+
+func TestCookieJarPersistence(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: "remember", Value: "me", Path: "/", Expires: time.Now().Add(time.Hour)})
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "cookies")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cookieFile := filepath.Join(dir, CookieFileName)
+
+	requests := parseRequests(t, `### Login
+GET {{host}}/login
+
+### Me
+GET {{host}}/me
+`, map[string]string{"host": srv.URL})
+
+	jar, err := ReadCookieJar(cookieFile)
+	assert.NoError(t, err)
+	assert.Empty(t, jar.All())
+
+	client := New(1)
+	client.SetCookieJar(jar)
+	_, err = client.ExecuteRequest(context.Background(), requests[0])
+	assert.NoError(t, err)
+	assert.Len(t, jar.All(), 2)
+	assert.NoError(t, jar.Save(cookieFile))
+
+	jar, err = ReadCookieJar(cookieFile)
+	assert.NoError(t, err)
+	cookies := jar.All()
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, "remember", cookies[0].Name)
+		assert.False(t, cookies[0].Expires.IsZero())
+		assert.Equal(t, "session", cookies[1].Name)
+		assert.Equal(t, "/", cookies[1].Path)
+		assert.True(t, cookies[1].Expires.IsZero())
+	}
+
+	client = New(1)
+	client.SetCookieJar(jar)
+	resp, err := client.ExecuteRequest(context.Background(), requests[1])
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.ReturnCode)
+
+	assert.NoError(t, ClearCookies(cookieFile))
+	jar, err = ReadCookieJar(cookieFile)
+	assert.NoError(t, err)
+	assert.Empty(t, jar.All())
+}
+
+func TestCookieFileFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookies")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cookieFile := filepath.Join(dir, CookieFileName)
+
+	jar := NewCookieJar()
+	jar.SetCookies(&url.URL{Scheme: "https", Host: "example.com", Path: "/"}, []*http.Cookie{
+		{Name: "session", Value: "abc", Path: "/", Secure: true, HttpOnly: true},
+		{Name: "theme", Value: "dark", Path: "/"},
+	})
+	assert.NoError(t, jar.Save(cookieFile))
+
+	info, err := os.Stat(cookieFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, err := ioutil.ReadFile(cookieFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "example.com\t/\tsession\tabc\t-1\ttrue\ttrue\n")
+
+	// Lines of earlier versions without the secure and httponly fields are still read
+	assert.NoError(t, ioutil.WriteFile(cookieFile, append(content, "example.com\t/\tlegacy\tyes\t-1\n"...), 0600))
+	jar, err = ReadCookieJar(cookieFile)
+	assert.NoError(t, err)
+	cookies := jar.All()
+	if assert.Len(t, cookies, 3) {
+		assert.Equal(t, "legacy", cookies[0].Name)
+		assert.True(t, cookies[1].Secure)
+		assert.True(t, cookies[1].HttpOnly)
+		assert.False(t, cookies[2].Secure)
+	}
+
+	names := func(u string) []string {
+		parsed, _ := url.Parse(u)
+		var names []string
+		for _, c := range jar.Cookies(parsed) {
+			names = append(names, c.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	assert.Equal(t, []string{"legacy", "theme"}, names("http://example.com/"))
+	assert.Equal(t, []string{"legacy", "session", "theme"}, names("https://example.com/"))
+}
+
+func TestOutputRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "output")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requests := parseRequests(t, `### Save
+GET {{host}}/first
+
+>> {{dir}}/out/user.json
+
+### Save again
+GET {{host}}/second
+
+>> {{dir}}/out/user.json
+
+### Overwrite
+GET {{host}}/third
+
+>>! {{dir}}/out/user.json
+`, map[string]string{"host": srv.URL, "dir": dir})
+
+	responses, err := New(1).Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "out", "user.json"), responses[0].SavedTo)
+	assert.Equal(t, filepath.Join(dir, "out", "user-1.json"), responses[1].SavedTo)
+	assert.Equal(t, filepath.Join(dir, "out", "user.json"), responses[2].SavedTo)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "out", "user.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"path":"/third"}`, string(content))
+
+	content, err = ioutil.ReadFile(filepath.Join(dir, "out", "user-1.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"path":"/second"}`, string(content))
+
+	// Paths use the variables set by the auth provider and are relative to the file of the request
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "save.http"), []byte(`### Login
+# @auth-provider
+GET `+srv.URL+`/login
+
+> {% client.global.set("user", response.body.path); %}
+
+### Save
+GET `+srv.URL+`/user
+
+>> out{{user}}.json
+`), 0644))
+	requests, err = parser.ParseFile(filepath.Join(dir, "save.http"), map[string]string{})
+	assert.NoError(t, err)
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(os.TempDir()))
+	defer os.Chdir(wd)
+	responses, err = New(1).Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "out", "login.json"), responses[1].SavedTo)
+	content, err = ioutil.ReadFile(filepath.Join(dir, "out", "login.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"path":"/user"}`, string(content))
+}
+
+func TestCompare(t *testing.T) {
+	diffs := Compare(
+		[]byte(`{"id":1,"name":"old","tags":["a","b"],"meta":{"ts":1},"gone":true}`),
+		[]byte(`{"id":1,"name":"new","tags":["a"],"meta":{"ts":2},"extra":null}`),
+		[]string{"meta.ts"},
+	)
+	assert.Equal(t, []Difference{
+		{Path: "$.extra", Kind: DifferenceAdded},
+		{Path: "$.gone", Kind: DifferenceRemoved, Expected: true},
+		{Path: "$.name", Kind: DifferenceChanged, Expected: "old", Actual: "new"},
+		{Path: "$.tags[1]", Kind: DifferenceRemoved, Expected: "b"},
+	}, diffs)
+
+	diffs = Compare([]byte("one\ntwo\nthree\n"), []byte("one\n2\nthree"), nil)
+	assert.Equal(t, []Difference{
+		{Path: "2", Kind: DifferenceRemoved, Expected: "two"},
+		{Path: "2", Kind: DifferenceAdded, Actual: "2"},
+	}, diffs)
+
+	assert.Empty(t, Compare([]byte(`{"a": [1, 2]}`), []byte(`{"a":[1,2]}`), nil))
+
+	diffs = Compare([]byte("head\none\ntwo\nthree\ntail\n"), []byte("head\none\n2\nthree\ntail\n"), nil)
+	assert.Equal(t, []Difference{
+		{Path: "3", Kind: DifferenceRemoved, Expected: "two"},
+		{Path: "3", Kind: DifferenceAdded, Actual: "2"},
+	}, diffs)
+
+	// Bodies with too many changed lines to diff them are reported from their first changed line
+	var expected, actual bytes.Buffer
+	expected.WriteString("head\n")
+	actual.WriteString("head\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&expected, "old %d\n", i)
+		fmt.Fprintf(&actual, "new %d\n", i)
+	}
+	assert.Equal(t, []Difference{
+		{Path: "2", Kind: DifferenceChanged, Expected: "old 0", Actual: "new 0"},
+	}, Compare(expected.Bytes(), actual.Bytes(), nil))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"up"}`))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "compare")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "same.json"), []byte(`{"status": "up"}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte(`{"status": "down"}`), 0644))
+
+	requests := parseRequests(t, `### Same
+GET {{host}}/status
+
+<> {{dir}}/same.json
+
+### Other
+GET {{host}}/status
+
+<> {{dir}}/other.json
+<> {{dir}}/same.json
+`, map[string]string{"host": srv.URL, "dir": dir})
+
+	client := New(1)
+	client.SetCompare(nil)
+	responses, err := client.Do(context.Background(), requests)
+	assert.Error(t, err)
+	assert.Empty(t, responses[0].Differences)
+	assert.Equal(t, []Difference{
+		{Path: "$.status", Kind: DifferenceChanged, Expected: "down", Actual: "up"},
+	}, responses[1].Differences)
+
+	// A missing previous response fails the comparison but keeps the response
+	responses, err = client.Do(context.Background(), parseRequests(t, "### Missing\nGET {{host}}/status\n\n<> {{dir}}/missing.json\n",
+		map[string]string{"host": srv.URL, "dir": dir}))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusOK, responses[0].ReturnCode)
+	assert.Equal(t, `{"status":"up"}`, string(responses[0].Body))
+	if assert.NotNil(t, responses[0].Error) {
+		assert.Equal(t, ErrorKindFile, responses[0].Error.Kind)
+	}
+	assert.Equal(t, ExitAssertionFailure, ExitCode(responses, nil))
+}
+
+func TestParallel(t *testing.T) {
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		_, _ = w.Write([]byte("path " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### One
+# @no-cookie-jar
+GET {{host}}/one
+
+### Two
+# @no-cookie-jar
+GET {{host}}/two
+
+### Three
+# @no-cookie-jar
+GET {{host}}/three
+
+### Four
+# @no-cookie-jar
+GET {{host}}/four
+`, map[string]string{"host": srv.URL})
+
+	client := New(2)
+	client.SetParallel()
+	responses, err := client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, maxActive)
+	for i, path := range []string{"/one", "/two", "/three", "/four"} {
+		assert.Equal(t, "path "+path, string(responses[i].Body))
+	}
+}
+
+func TestImplicitDependencies(t *testing.T) {
+	requests := parseRequests(t, `### Login
+# @no-cookie-jar
+POST https://httpbin.org/post
+
+> {% client.global.set("token", response.body.json.token); %}
+
+### Independent
+# @no-cookie-jar
+GET https://httpbin.org/get
+
+### Uses token
+# @no-cookie-jar
+GET https://httpbin.org/headers
+Authorization: Bearer {{token}}
+
+### Refresh
+# @no-cookie-jar
+POST https://httpbin.org/post
+
+> {% client.global.set("token", response.body.json.token); %}
+
+### Save
+# @no-cookie-jar
+GET https://httpbin.org/get
+
+>> ./out.json
+
+### Save again
+# @no-cookie-jar
+GET https://httpbin.org/get
+
+>> ./out.json
+
+### Cookie login
+POST https://login.example.com/login
+
+### Cookie session
+GET https://api.example.com/user
+
+### Other site
+GET https://httpbin.org/cookies
+`, nil)
+
+	assert.Equal(t, [][]int{nil, nil, {0}, {0}, nil, {4}, nil, {6}, nil}, implicitDependencies(requests))
+}
+
+func TestParallelCookieLogin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			time.Sleep(50 * time.Millisecond)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/"})
+		case "/user":
+			if c, err := r.Cookie("session"); err != nil || c.Value != "s3cr3t" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Login
+POST {{host}}/login
+
+### User
+GET {{host}}/user
+`, map[string]string{"host": srv.URL})
+
+	client := New(2)
+	client.SetParallel()
+	responses, err := client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, responses[1].ReturnCode)
+}
+
+func TestDependsOn(t *testing.T) {
+	var mu sync.Mutex
+	order := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		order = append(order, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Get user
+# @depends-on login
+GET {{host}}/user
+
+### Login
+# @name login
+POST {{host}}/login
+
+### Broken
+# @name broken
+GET {{host}}/broken
+
+### Needs broken
+# @name needs-broken
+# @depends-on broken
+GET {{host}}/needs-broken
+
+### Needs needs-broken
+# @depends-on needs-broken
+GET {{host}}/needs-needs-broken
+`, map[string]string{"host": srv.URL})
+
+	failOn, err := ParseStatusSet([]string{"5xx"})
+	assert.NoError(t, err)
+	client := New(4)
+	client.SetFailOnStatus(failOn)
+	responses, err := client.Do(context.Background(), requests)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"/login", "/user", "/broken"}, order)
+	assert.Equal(t, http.StatusOK, responses[0].ReturnCode)
+	assert.Equal(t, http.StatusInternalServerError, responses[2].ReturnCode)
+	assert.Equal(t, `prerequisite "broken" failed`, responses[3].Skipped)
+	assert.Equal(t, `prerequisite "needs-broken" was skipped`, responses[4].Skipped)
+
+	// Without --fail-on-status no status code fails a request
+	order = order[:0]
+	responses, err = New(4).Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/login", "/user", "/broken", "/needs-broken", "/needs-needs-broken"}, order)
+	assert.Empty(t, responses[3].Skipped)
+}
+
+func TestErrors(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsSrv.Close()
+	slowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slowSrv.Close()
+	closedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closedSrv.Close()
+
+	requests := parseRequests(t, `### TLS
+GET {{tls}}/
+
+### Timeout
+# @timeout 50ms
+GET {{slow}}/
+
+### Connect
+GET {{closed}}/
+
+### Handler
+GET {{slow}}/
+
+> ./does-not-exist.js
+`, map[string]string{"tls": tlsSrv.URL, "slow": slowSrv.URL, "closed": closedSrv.URL})
+
+	responses, err := New(4).Do(context.Background(), requests)
+	assert.Error(t, err)
+	for i, kind := range []ErrorKind{ErrorKindTLS, ErrorKindTimeout, ErrorKindConnect, ErrorKindScript} {
+		if assert.NotNil(t, responses[i].Error, requests[i].Name) {
+			assert.Equal(t, kind, responses[i].Error.Kind, requests[i].Name)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestTimeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Default
+GET {{host}}/
+
+### Directive
+# @timeout 1 s
+GET {{host}}/
+`, map[string]string{"host": srv.URL})
+
+	client := New(1)
+	client.SetTimeout(50 * time.Millisecond)
+	responses, err := client.Do(context.Background(), requests)
+	assert.Error(t, err)
+	if assert.NotNil(t, responses[0].Error) {
+		assert.Equal(t, ErrorKindTimeout, responses[0].Error.Kind)
+	}
+	assert.Nil(t, responses[1].Error)
+	assert.Equal(t, http.StatusOK, responses[1].ReturnCode)
+
+	dialErr := &url.Error{Op: "Get", URL: srv.URL, Err: &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}}
+	assert.Equal(t, ErrorKindConnectTimeout, NewError(dialErr).Kind)
+	readErr := &url.Error{Op: "Get", URL: srv.URL, Err: &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}}
+	assert.Equal(t, ErrorKindTimeout, NewError(readErr).Kind)
+}
+
+func TestRetries(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		n := calls[r.URL.Path]
+		mu.Unlock()
+		if r.URL.Path == "/flaky" && n > 2 {
+			fmt.Fprint(w, "ok!")
+			return
+		}
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Flaky
+GET {{host}}/flaky
+
+### Down
+# @retry 1 backoff=constant
+GET {{host}}/down
+
+### Create
+POST {{host}}/create
+
+### Create with retry
+# @retry 1 delay=1ms
+POST {{host}}/create-with-retry
+`, map[string]string{"host": srv.URL})
+
+	client := New(1)
+	policy := DefaultRetryPolicy
+	policy.Retries = 3
+	policy.Delay = 10 * time.Millisecond
+	client.SetRetryPolicy(policy)
+	responses, err := client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, responses[0].ReturnCode)
+	if assert.Len(t, responses[0].Attempts, 3) {
+		assert.Equal(t, http.StatusServiceUnavailable, responses[0].Attempts[0].ReturnCode)
+		assert.Equal(t, 10*time.Millisecond, responses[0].Attempts[0].Delay)
+		assert.Equal(t, 20*time.Millisecond, responses[0].Attempts[1].Delay)
+		assert.Equal(t, http.StatusOK, responses[0].Attempts[2].ReturnCode)
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, responses[1].ReturnCode)
+	assert.Len(t, responses[1].Attempts, 2)
+	assert.Equal(t, 2, calls["/down"])
+	// Requests which are not idempotent are only retried if they opt in with the retry directive
+	assert.Empty(t, responses[2].Attempts)
+	assert.Equal(t, 1, calls["/create"])
+	assert.Len(t, responses[3].Attempts, 2)
+	assert.Equal(t, 2, calls["/create-with-retry"])
+
+	closedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closedSrv.Close()
+	responses, err = client.Do(context.Background(), parseRequests(t, "### Closed\n# @retry 1 delay=1ms\nGET {{host}}/\n",
+		map[string]string{"host": closedSrv.URL}))
+	assert.Error(t, err)
+	if assert.NotNil(t, responses[0].Error) {
+		assert.Equal(t, ErrorKindConnect, responses[0].Error.Kind)
+	}
+	assert.Len(t, responses[0].Attempts, 2)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	on, err := ParseRetryOn([]string{"502", "5xx", "connect", "timeout"})
+	assert.NoError(t, err)
+	assert.True(t, on.Statuses.Contains(504))
+	assert.Equal(t, []ErrorKind{ErrorKindConnect, ErrorKindTimeout}, on.Kinds)
+	_, err = ParseRetryOn([]string{"sometimes"})
+	assert.Error(t, err)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := RetryPolicy{Backoff: BackoffExponential, Delay: time.Second, Max: 30 * time.Second}
+	assert.Equal(t, time.Second, policy.delay(0, nil, now))
+	assert.Equal(t, 8*time.Second, policy.delay(3, nil, now))
+	assert.Equal(t, 30*time.Second, policy.delay(10, nil, now))
+
+	resp := &Response{Header: http.Header{"Retry-After": {"5"}}}
+	assert.Equal(t, 5*time.Second, policy.delay(0, resp, now))
+	resp.Header.Set("Retry-After", now.Add(time.Minute).Format(http.TimeFormat))
+	assert.Equal(t, 30*time.Second, policy.delay(0, resp, now))
+
+	policy.Backoff = BackoffLinear
+	assert.Equal(t, 3*time.Second, policy.delay(2, nil, now))
+}
+
+func TestPollUntil(t *testing.T) {
+	var mu sync.Mutex
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/accepted" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		mu.Lock()
+		polls++
+		n := polls
+		mu.Unlock()
+		state := "running"
+		if n > 2 {
+			state = "done"
+		}
+		fmt.Fprintf(w, `{"job": {"state": "%s"}}`, state)
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Job
+# @poll-until $.job.state == "done" interval=10ms
+GET {{host}}/job
+
+### Accepted
+# @poll-until status == 200 interval=10ms timeout=50ms
+GET {{host}}/accepted
+`, map[string]string{"host": srv.URL})
+
+	var progress bytes.Buffer
+	client := New(1)
+	client.SetProgress(&progress)
+	responses, err := client.Do(context.Background(), requests)
+	assert.Error(t, err)
+
+	assert.Nil(t, responses[0].Error)
+	assert.Equal(t, 3, responses[0].Polls)
+	assert.JSONEq(t, `{"job": {"state": "done"}}`, string(responses[0].Body))
+
+	if assert.NotNil(t, responses[1].Error) {
+		assert.Equal(t, ErrorKindPollTimeout, responses[1].Error.Kind)
+	}
+	assert.Equal(t, http.StatusAccepted, responses[1].ReturnCode)
+	assert.True(t, responses[1].Polls > 1)
+	assert.Equal(t, ExitAssertionFailure, ExitCode(responses, nil))
+
+	assert.Contains(t, progress.String(), `polling "Job": $.job.state is "running" (request 1)`)
+	assert.Contains(t, progress.String(), `polling "Accepted": status is 202 (request 1)`)
+}
+
+func TestPollUntilOutputRedirect(t *testing.T) {
+	var mu sync.Mutex
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls++
+		n := polls
+		mu.Unlock()
+		state := "running"
+		if n > 1 {
+			state = "done"
+		}
+		fmt.Fprintf(w, `{"state": "%s"}`, state)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "output")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requests := parseRequests(t, `### Job
+# @poll-until $.state == "done" interval=10ms timeout=1s
+GET {{host}}/job
+
+>> {{dir}}/job.json
+`, map[string]string{"host": srv.URL, "dir": dir})
+
+	responses, err := New(1).Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Nil(t, responses[0].Error)
+	assert.Equal(t, 2, responses[0].Polls)
+	assert.Empty(t, responses[0].Body)
+
+	content, err := ioutil.ReadFile(responses[0].SavedTo)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"state": "done"}`, string(content))
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	var doc interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"items": [{"id": 1}, {"id": 2}], "odd key": "x"}`), &doc))
+
+	tc := []struct {
+		path  string
+		value interface{}
+		found bool
+	}{
+		{path: "$.items[1].id", value: 2.0, found: true},
+		{path: "$['odd key']", value: "x", found: true},
+		{path: "$.items[2].id"},
+		{path: "$.missing"},
+		{path: "$.items.id"},
+	}
+	for _, c := range tc {
+		value, found, err := lookupJSONPath(doc, c.path)
+		assert.NoError(t, err, c.path)
+		assert.Equal(t, c.found, found, c.path)
+		assert.Equal(t, c.value, value, c.path)
+	}
+
+	for _, path := range []string{"items", "$.items[0", "$..items", "$[x]"} {
+		_, _, err := lookupJSONPath(doc, path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestResponseBody(t *testing.T) {
+	tc := []struct {
+		contentType string
+		body        []byte
+		content     string
+		encoding    string
+	}{
+		{contentType: "text/plain", body: []byte("abcd"), content: `"abcd"`},
+		{contentType: "application/json; charset=utf-8", body: []byte(`{"id": 1}`), content: `{"id":1}`},
+		{contentType: "application/problem+json", body: []byte(`[1, 2]`), content: `[1,2]`},
+		{contentType: "application/json", body: []byte(`{"id":`), content: `"{\"id\":"`},
+		{contentType: "text/plain; charset=ISO-8859-1", body: []byte{'K', 0xe4, 's', 'e'}, content: `"Käse"`},
+		{contentType: "image/png", body: []byte{0x89, 'P', 'N', 'G'}, content: `"iVBORw=="`, encoding: EncodingBase64},
+		{contentType: "text/plain", body: []byte{0xff, 0xfe}, content: `"//4="`, encoding: EncodingBase64},
+		{body: []byte("abcd"), content: `"abcd"`},
+		{contentType: "application/json"},
+	}
+	for _, c := range tc {
+		var resp Response
+		resp.setBody(c.contentType, c.body)
+		out, err := json.Marshal(resp)
+		assert.NoError(t, err, c.contentType)
+
+		var fields map[string]json.RawMessage
+		assert.NoError(t, json.Unmarshal(out, &fields))
+		if c.content == "" {
+			assert.NotContains(t, fields, "Content", c.contentType)
+			continue
+		}
+		assert.Equal(t, c.content, string(fields["Content"]), c.contentType)
+		assert.Equal(t, c.encoding, resp.Encoding, c.contentType)
+	}
+}
+
+func TestResponseModel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true})
+		http.SetCookie(w, &http.Cookie{Name: "theme", Value: "dark", SameSite: http.SameSiteLaxMode})
+		w.Header().Add("X-Multi", "one")
+		w.Header().Add("X-Multi", "two")
+		w.Header().Set("Trailer", "X-Checksum")
+		fmt.Fprint(w, "body!")
+		w.Header().Set("X-Checksum", "42")
+	}))
+	defer srv.Close()
+	closedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closedSrv.Close()
+
+	requests := parseRequests(t, `### Moved
+# @name moved
+GET {{host}}/old
+
+### Unreachable
+# @name unreachable
+DELETE {{closed}}/gone
+
+### Skipped
+# @depends-on unreachable
+HEAD {{host}}/skipped
+`, map[string]string{"host": srv.URL, "closed": closedSrv.URL})
+
+	responses, _ := New(1).Do(context.Background(), requests)
+	resp := responses[0]
+	assert.Equal(t, "moved", resp.Name)
+	assert.Equal(t, "GET", resp.Method)
+	assert.Equal(t, srv.URL+"/new", resp.URL)
+	assert.Equal(t, []string{"one", "two"}, resp.Header["X-Multi"])
+	assert.Len(t, resp.Header["Set-Cookie"], 2)
+	assert.Equal(t, []Cookie{
+		{Name: "session", Value: "abc", Path: "/", HttpOnly: true},
+		{Name: "theme", Value: "dark", SameSite: "Lax"},
+	}, resp.Cookies)
+	assert.Equal(t, "42", resp.Trailer.Get("X-Checksum"))
+	assert.Equal(t, int64(5), resp.BodySize)
+	assert.True(t, resp.Duration > 0)
+
+	legacy := resp.Legacy()
+	assert.Equal(t, "one, two", legacy.Header["X-Multi"])
+	assert.Equal(t, resp.Content, legacy.Content)
+
+	assert.Equal(t, "unreachable", responses[1].Name)
+	assert.Equal(t, "DELETE", responses[1].Method)
+	assert.Equal(t, closedSrv.URL+"/gone", responses[1].URL)
+	assert.NotNil(t, responses[1].Error)
+	assert.Equal(t, "Skipped", responses[2].Name)
+	assert.Equal(t, "HEAD", responses[2].Method)
+	assert.Equal(t, srv.URL+"/skipped", responses[2].URL)
+	assert.NotEmpty(t, responses[2].Skipped)
+}
+
+func TestTimings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprint(w, "timed!")
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### First
+GET {{host}}/
+
+### Second
+GET {{host}}/
+`, map[string]string{"host": srv.URL})
+
+	responses, err := New(1).Do(context.Background(), requests)
+	assert.NoError(t, err)
+
+	first := responses[0].Timings
+	if assert.NotNil(t, first) {
+		assert.False(t, first.Reused)
+		assert.True(t, first.Connect > 0)
+		assert.Zero(t, first.TLSHandshake)
+		assert.True(t, first.FirstByte >= 10*time.Millisecond)
+		assert.True(t, first.Total >= first.FirstByte)
+	}
+
+	second := responses[1].Timings
+	if assert.NotNil(t, second) {
+		assert.True(t, second.Reused)
+		assert.Zero(t, second.Connect)
+		assert.True(t, second.FirstByte >= 10*time.Millisecond)
+	}
+}
+
+func TestResponseCallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Slow
+# @no-cookie-jar
+GET {{host}}/slow
+
+### Fast
+# @no-cookie-jar
+GET {{host}}/fast
+
+### Dependent
+# @depends-on Slow
+# @no-cookie-jar
+GET {{host}}/dependent
+`, map[string]string{"host": srv.URL})
+
+	order := make([]string, 0)
+	client := New(2)
+	client.SetParallel()
+	client.SetResponseCallback(func(i int, resp Response) {
+		assert.Equal(t, requests[i].Name, resp.Name)
+		order = append(order, resp.Name)
+	})
+	responses, err := client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Len(t, responses, 3)
+	assert.Equal(t, []string{"Fast", "Slow", "Dependent"}, order)
+}
+
+func TestReporters(t *testing.T) {
+	responses := []Response{
+		{Name: "ok", Method: "GET", URL: "http://localhost/ok", HTTPVersion: "HTTP/1.1", ReturnCode: 200,
+			Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("fine."), Content: json.RawMessage(`"fine."`)},
+		{Name: "missing", Method: "GET", URL: "http://localhost/missing", HTTPVersion: "HTTP/1.1", ReturnCode: 404},
+		{Name: "down", Method: "POST", URL: "http://localhost/down", Error: &Error{Kind: ErrorKindConnect, Message: "refused"}},
+		{Name: "later", Method: "GET", URL: "http://localhost/later", Skipped: `prerequisite "down" failed`},
+	}
+	report := func(format string) string {
+		var out bytes.Buffer
+		r, err := NewReporter(format, &out, ReportOptions{Name: "test.http", FailOn: StatusSet{{400, 599}}})
+		assert.NoError(t, err)
+		for i, resp := range responses {
+			assert.NoError(t, r.Report(i, resp))
+		}
+		assert.NoError(t, r.Finish(responses))
+		return out.String()
+	}
+
+	var array []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(report(OutputJSON)), &array))
+	assert.Len(t, array, 4)
+
+	lines := strings.Split(strings.TrimSpace(report(OutputJSONL)), "\n")
+	if assert.Len(t, lines, 4) {
+		assert.Contains(t, lines[1], `"Name":"missing"`)
+	}
+
+	var suites junitTestSuites
+	assert.NoError(t, xml.Unmarshal([]byte(report(OutputJUnit)), &suites))
+	if assert.Len(t, suites.Suites, 1) {
+		suite := suites.Suites[0]
+		assert.Equal(t, "test.http", suite.Name)
+		assert.Equal(t, []int{4, 1, 1, 1}, []int{suite.Tests, suite.Failures, suite.Errors, suite.Skipped})
+		assert.Equal(t, "404 Not Found", suite.Cases[1].Failure.Message)
+		assert.Equal(t, "connect", suite.Cases[2].Error.Type)
+	}
+
+	tap := report(OutputTAP)
+	assert.Contains(t, tap, "1..4\nok 1 - ok GET http://localhost/ok\nnot ok 2 - missing GET http://localhost/missing\n")
+	assert.Contains(t, tap, "not ok 3 - down POST http://localhost/down\n  ---\n  message: \"error: connect: refused\"\n")
+	assert.Contains(t, tap, "ok 4 - later GET http://localhost/later # SKIP prerequisite \"down\" failed\n")
+
+	raw := report(OutputHTTP)
+	assert.Contains(t, raw, "### ok\n# GET http://localhost/ok\nHTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nfine.")
+	assert.Contains(t, raw, "### down\n# POST http://localhost/down\n# error: connect: refused\n")
+
+	// Bodies saved to a file are not kept in the response
+	var saved bytes.Buffer
+	assert.NoError(t, (&httpReporter{w: &saved}).Report(0, Response{Name: "saved", Method: "GET", URL: "http://localhost/saved",
+		HTTPVersion: "HTTP/1.1", ReturnCode: 200, SavedTo: "out/saved.json"}))
+	assert.Equal(t, "### saved\n# GET http://localhost/saved\nHTTP/1.1 200 OK\r\n\r\n# body saved to out/saved.json\n\n", saved.String())
+
+	pretty := report(OutputPretty)
+	assert.Contains(t, pretty, "### missing\nGET http://localhost/missing\n404 Not Found")
+	assert.Contains(t, pretty, "4 requests, 3 failed")
+	assert.NotContains(t, pretty, "\x1b[")
+
+	_, err := NewReporter("yaml", nil, ReportOptions{})
+	assert.Error(t, err)
+}
+
+func TestStream(t *testing.T) {
+	hanging := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hang" {
+			close(hanging)
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### First
+GET {{host}}/first
+
+### Hang
+GET {{host}}/hang
+
+### Never
+GET {{host}}/never
+`, map[string]string{"host": srv.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// Interrupt the run while the second request is running
+		<-hanging
+		cancel()
+	}()
+	results := make([]Result, 0)
+	for result := range New(1).Stream(ctx, requests) {
+		results = append(results, result)
+	}
+
+	if assert.Len(t, results, 4) {
+		assert.Equal(t, 0, results[0].Index)
+		assert.Equal(t, http.StatusOK, results[0].Response.ReturnCode)
+		assert.Equal(t, 1, results[1].Index)
+		if assert.NotNil(t, results[1].Response.Error) {
+			assert.Equal(t, ErrorKindCanceled, results[1].Response.Error.Kind)
+		}
+		assert.Equal(t, "run interrupted", results[2].Response.Skipped)
+		// The error of the run comes last
+		assert.Equal(t, -1, results[3].Index)
+		assert.Error(t, results[3].Err)
+	}
+
+	results = results[:0]
+	for result := range New(1).Stream(context.Background(), requests[:1]) {
+		results = append(results, result)
+	}
+	if assert.Len(t, results, 1) {
+		assert.NoError(t, results[0].Err)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Answer", "42")
+		fmt.Fprint(w, "logged!")
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Create
+POST {{host}}/items
+Content-Type: text/plain
+
+new item
+
+### Secret
+# @no-log
+GET {{host}}/secret
+`, map[string]string{"host": srv.URL})
+
+	levels := []struct {
+		level    LogLevel
+		contains []string
+		missing  []string
+	}{
+		{level: LogRequests, contains: []string{"> Create: POST " + srv.URL + "/items", "< Create: 200 OK"}, missing: []string{"X-Answer"}},
+		{level: LogHeaders, contains: []string{"Content-Type: text/plain", "new item", "X-Answer: 42", "logged!"}, missing: []string{"HTTP/1.1"}},
+		{level: LogWire, contains: []string{"POST /items HTTP/1.1", "HTTP/1.1 200 OK", "logged!"}},
+	}
+	for _, l := range levels {
+		var log bytes.Buffer
+		client := New(1)
+		client.SetLogger(NewLogger(&log, l.level))
+		_, err := client.Do(context.Background(), requests)
+		assert.NoError(t, err)
+
+		for _, text := range l.contains {
+			assert.Contains(t, log.String(), text, "level %d", l.level)
+		}
+		for _, text := range append(l.missing, "secret") {
+			assert.NotContains(t, log.String(), text, "level %d", l.level)
+		}
+	}
+}
+
+func TestWireLogLimitsBody(t *testing.T) {
+	download := strings.Repeat("0123456789abcdef", maxWireBody/8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, download)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "wire")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requests := parseRequests(t, `### Download
+GET {{host}}/download
+
+>> {{dir}}/download.txt
+`, map[string]string{"host": srv.URL, "dir": dir})
+
+	var log bytes.Buffer
+	client := New(1)
+	client.SetLogger(NewLogger(&log, LogWire))
+	_, err = client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+
+	assert.Contains(t, log.String(), fmt.Sprintf("<body truncated after %d bytes>", maxWireBody))
+	assert.Less(t, log.Len(), len(download))
+	content, err := ioutil.ReadFile(filepath.Join(dir, "download.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, download, string(content))
+}
+
+func TestRedactor(t *testing.T) {
+	redactor, err := NewRedactor([]string{"s3cr3t", `pa"ss`}, []string{`pin=\d{4}`})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "token [redacted] and [redacted]", redactor.String(`token s3cr3t and pa"ss`))
+	assert.Equal(t, "GET /login?[redacted]&user=me", redactor.String("GET /login?pin=1234&user=me"))
+	assert.Equal(t, "Authorization: [redacted]\r\nAccept: */*", redactor.String("Authorization: Basic dXNlcjpwYXNz\r\nAccept: */*"))
+
+	resp := Response{
+		URL:     "https://localhost/me?key=s3cr3t",
+		Header:  http.Header{"Set-Cookie": {"sid=abc"}, "X-Echo": {"s3cr3t"}, "Content-Type": {"application/json"}},
+		Cookies: []Cookie{{Name: "sid", Value: "abc"}},
+		Error:   &Error{Kind: ErrorKindOther, Message: "bad key s3cr3t"},
+		Body:    []byte(`{"password":"pa\"ss","token":"s3cr3t"}`),
+		Content: json.RawMessage(`{"password":"pa\"ss","token":"s3cr3t"}`),
+	}
+	redacted := redactor.Response(resp)
+	assert.Equal(t, "https://localhost/me?key=[redacted]", redacted.URL)
+	assert.Equal(t, http.Header{"Set-Cookie": {"[redacted]"}, "X-Echo": {"[redacted]"}, "Content-Type": {"application/json"}}, redacted.Header)
+	assert.Equal(t, "[redacted]", redacted.Cookies[0].Value)
+	assert.Equal(t, "bad key [redacted]", redacted.Error.Message)
+	assert.JSONEq(t, `{"password":"[redacted]","token":"[redacted]"}`, string(redacted.Content))
+	// The original response is left untouched
+	assert.Equal(t, "abc", resp.Cookies[0].Value)
+	assert.Equal(t, "bad key s3cr3t", resp.Error.Message)
+
+	var nilRedactor *Redactor
+	assert.Equal(t, resp, nilRedactor.Response(resp))
+
+	_, err = NewRedactor(nil, []string{"("})
+	assert.Error(t, err)
+
+	// Numbers, booleans and short values of the private environment are not redacted
+	redactor, err = NewRedactor([]string{"8080", "true", "1", "abc", "s3cr3t"}, nil)
+	assert.NoError(t, err)
+	body := `{"port":8080,"secure":true,"id":1,"name":"abc","token":"s3cr3t"}`
+	redacted = redactor.Response(Response{Body: []byte(body), Content: json.RawMessage(body)})
+	assert.JSONEq(t, `{"port":8080,"secure":true,"id":1,"name":"abc","token":"[redacted]"}`, string(redacted.Content))
+}
+
+func TestRedactError(t *testing.T) {
+	redactor, err := NewRedactor([]string{"s3cr3t"}, nil)
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+	_, runErr := New(1).Do(context.Background(), parseRequests(t, "### Closed\nGET {{host}}/?token=s3cr3t\n",
+		map[string]string{"host": srv.URL}))
+	assert.Error(t, runErr)
+	assert.Contains(t, runErr.Error(), "token=s3cr3t")
+
+	redacted := redactor.RedactError(runErr)
+	assert.NotContains(t, redacted.Error(), "s3cr3t")
+	assert.Contains(t, redacted.Error(), "token=[redacted]")
+	var requestErr *Error
+	if assert.True(t, errors.As(redacted, &requestErr)) {
+		assert.Equal(t, ErrorKindConnect, requestErr.Kind)
+	}
+
+	var nilRedactor *Redactor
+	assert.Equal(t, runErr, nilRedactor.RedactError(runErr))
+	assert.Nil(t, redactor.RedactError(nil))
+}
+
+func TestRedaction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Api-Key", r.Header.Get("X-Api-Key"))
+		fmt.Fprintf(w, "your key is %s", r.Header.Get("X-Api-Key"))
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Key
+GET {{host}}/key
+X-Api-Key: {{key}}
+`, map[string]string{"host": srv.URL, "key": "k3y-from-private-env"})
+	redactor, err := NewRedactor([]string{"k3y-from-private-env"}, nil)
+	assert.NoError(t, err)
+
+	var log, out bytes.Buffer
+	logger := NewLogger(&log, LogWire)
+	logger.SetRedactor(redactor)
+	reporter, err := NewReporter(OutputJSON, &out, ReportOptions{})
+	assert.NoError(t, err)
+	reporter = RedactingReporter(reporter, redactor)
+
+	client := New(1)
+	client.SetLogger(logger)
+	client.SetResponseCallback(func(i int, resp Response) {
+		assert.NoError(t, reporter.Report(i, resp))
+	})
+	responses, err := client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.NoError(t, reporter.Finish(responses))
+
+	assert.Equal(t, "your key is k3y-from-private-env", string(responses[0].Body))
+	for _, text := range []string{log.String(), out.String()} {
+		assert.NotContains(t, text, "k3y-from-private-env")
+		assert.Contains(t, text, "your key is [redacted]")
+	}
+	assert.Contains(t, log.String(), "X-Api-Key: [redacted]")
+}
+
+func TestBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "passwd" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Shorthand
+GET {{host}}/basic
+Authorization: Basic user passwd
+
+### Variables
+GET {{host}}/basic
+Authorization: Basic {{username}} {{password}}
+
+### Encoded
+GET {{host}}/basic
+Authorization: Basic dXNlcjpwYXNzd2Q=
+
+### Wrong password
+GET {{host}}/basic
+authorization: basic user wrong
+`, map[string]string{"host": srv.URL, "username": "user", "password": "passwd"})
+
+	responses, err := New(1).Do(context.Background(), requests)
+	assert.NoError(t, err)
+	for i, status := range []int{200, 200, 200, 401} {
+		assert.Equal(t, status, responses[i].ReturnCode, requests[i].Name)
+	}
+}
+
+// digestServer requires digest access authentication of user with password passwd using the algorithm
+// of the path, like /MD5 or /SHA-256, and records the nonce counts of authorized requests
+func digestServer(counts *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		algorithm := strings.TrimPrefix(r.URL.Path, "/")
+		h := md5.New
+		if algorithm == "SHA-256" {
+			h = sha256.New
+		}
+		digest := func(parts ...string) string {
+			d := h()
+			io.WriteString(d, strings.Join(parts, ":"))
+			return hex.EncodeToString(d.Sum(nil))
+		}
+
+		params := parseAuthParams(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "))
+		ha1 := digest("user", "test", "passwd")
+		ha2 := digest(r.Method, r.URL.RequestURI())
+		expected := digest(ha1, "n0nce", params["nc"], params["cnonce"], "auth", ha2)
+		if params["response"] != expected || params["algorithm"] != algorithm || params["opaque"] != "0paque" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="test", qop="auth,auth-int", nonce="n0nce", opaque="0paque", algorithm=`+algorithm)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		*counts = append(*counts, params["nc"])
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+}
+
+func TestDigestAuth(t *testing.T) {
+	var counts []string
+	srv := digestServer(&counts)
+	defer srv.Close()
+
+	requests := parseRequests(t, `### MD5
+POST {{host}}/MD5
+Authorization: Digest user passwd
+Content-Type: text/plain
+
+hello
+
+### MD5 again
+GET {{host}}/MD5
+Authorization: Digest {{username}} {{password}}
+
+### SHA-256
+GET {{host}}/SHA-256?q=1
+Authorization: Digest user passwd
+
+### Wrong password
+GET {{host}}/MD5
+Authorization: Digest user wrong
+
+### Without authorization
+GET {{host}}/MD5
+`, map[string]string{"host": srv.URL, "username": "user", "password": "passwd"})
+
+	responses, err := New(1).Do(context.Background(), requests)
+	assert.NoError(t, err)
+	for i, status := range []int{200, 200, 200, 401, 401} {
+		assert.Equal(t, status, responses[i].ReturnCode, requests[i].Name)
+	}
+	assert.Equal(t, "hello", strings.TrimSpace(string(responses[0].Body)))
+	// The second request reuses the nonce of the first
+	assert.Equal(t, []string{"00000001", "00000002", "00000001"}, counts)
+
+	// Bodies which can not be sent again are buffered in a copy of the request
+	transport := &digestTransport{next: http.DefaultTransport}
+	ctx := withDigestAuth(context.Background(), digestCredentials{user: "user", password: "passwd"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/SHA-256", ioutil.NopCloser(strings.NewReader("hello")))
+	assert.NoError(t, err)
+	body := req.Body
+	resp, err := transport.RoundTrip(req)
+	if assert.NoError(t, err) {
+		content, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello", strings.TrimSpace(string(content)))
+	}
+	assert.Nil(t, req.GetBody)
+	assert.Equal(t, body, req.Body)
+}
+
+func TestDigestChallenge(t *testing.T) {
+	header := http.Header{}
+	header.Add("WWW-Authenticate", `Basic realm="test"`)
+	header.Add("WWW-Authenticate", `Digest realm="a, b", nonce="abc", qop="auth", algorithm=MD5`)
+	header.Add("WWW-Authenticate", `Digest realm="a, b", nonce="abc", qop="auth", algorithm=SHA-256, userhash=true`)
+	challenge, ok := parseDigestChallenge(header)
+	if assert.True(t, ok) {
+		assert.Equal(t, &digestChallenge{realm: "a, b", nonce: "abc", qop: "auth", algorithm: "SHA-256", userhash: true}, challenge)
+	}
+
+	_, ok = parseDigestChallenge(http.Header{"Www-Authenticate": {`Digest realm="x", nonce="abc", qop="auth-int"`}})
+	assert.False(t, ok)
+}
+
+// tokenServer is an OAuth 2.0 authorization server for the client cli with the secret s3cret and the user
+// user with the password passwd. It issues numbered tokens valid for an hour and records the grant types.
+type tokenServer struct {
+	*httptest.Server
+	issued    int
+	grants    []string
+	challenge string
+	// pending is the number of device token requests answered with authorization_pending
+	pending int
+	// lifetime is the expires_in of the issued tokens in seconds
+	lifetime int
+}
+
+func newTokenServer() *tokenServer {
+	s := &tokenServer{lifetime: 3600}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		s.challenge = r.URL.Query().Get("code_challenge")
+		redirect, _ := url.Parse(r.URL.Query().Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"c0de"}, "state": {r.URL.Query().Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"device_code":"dev1ce","user_code":"ABCD","verification_uri":"https://example.com/device","interval":1}`)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fail := func(code string) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":"%s"}`, code)
+		}
+		client, secret, basic := r.BasicAuth()
+		if !basic {
+			client, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+		}
+		if client != "cli" || (secret != "" && secret != "s3cret") {
+			fail("invalid_client")
+			return
+		}
+
+		grant := r.FormValue("grant_type")
+		switch grant {
+		case "client_credentials":
+			if secret == "" {
+				fail("unauthorized_client")
+				return
+			}
+		case "password":
+			if r.FormValue("username") != "user" || r.FormValue("password") != "passwd" {
+				fail("invalid_grant")
+				return
+			}
+		case "refresh_token":
+			if r.FormValue("refresh_token") != fmt.Sprintf("refresh-%d", s.issued) {
+				fail("invalid_grant")
+				return
+			}
+		case "authorization_code":
+			sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			if r.FormValue("code") != "c0de" || base64.RawURLEncoding.EncodeToString(sum[:]) != s.challenge {
+				fail("invalid_grant")
+				return
+			}
+		case "urn:ietf:params:oauth:grant-type:device_code":
+			if s.pending > 0 {
+				s.pending--
+				fail("authorization_pending")
+				return
+			}
+		default:
+			fail("unsupported_grant_type")
+			return
+		}
+		s.grants = append(s.grants, grant)
+		s.issued++
+		fmt.Fprintf(w, `{"access_token":"token-%d","refresh_token":"refresh-%d","id_token":"id-%d","token_type":"Bearer","expires_in":%d}`,
+			s.issued, s.issued, s.issued, s.lifetime)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func TestTokenManager(t *testing.T) {
+	srv := newTokenServer()
+	defer srv.Close()
+
+	tokens := NewTokenManager(map[string]AuthConfig{
+		"cc":     {Type: "OAuth2", GrantType: GrantClientCredentials, TokenURL: srv.URL + "/token", ClientID: "cli", ClientSecret: "s3cret"},
+		"pw":     {GrantType: GrantPassword, TokenURL: srv.URL + "/token", ClientID: "cli", ClientSecret: "s3cret", ClientCredentials: "in body", Username: "user", Password: "passwd"},
+		"code":   {GrantType: GrantAuthorizationCode, AuthURL: srv.URL + "/authorize", TokenURL: srv.URL + "/token", ClientID: "cli", PKCE: PKCE{Enabled: true}},
+		"device": {GrantType: GrantDeviceAuthorization, DeviceAuthURL: srv.URL + "/device", TokenURL: srv.URL + "/token", ClientID: "cli", ClientCredentials: "none"},
+		"saml":   {Type: "SAML"},
+	})
+	now := time.Now()
+	tokens.now = func() time.Time { return now }
+	var progress bytes.Buffer
+	tokens.SetProgress(&progress)
+	tokens.browse = func(authURL string) error {
+		// The browser follows the redirect to the listener of the token manager
+		resp, err := http.Get(authURL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	token, err := tokens.Token(context.Background(), "cc")
+	assert.NoError(t, err)
+	assert.Equal(t, &Token{AccessToken: "token-1", RefreshToken: "refresh-1", IDToken: "id-1", Expiry: now.Add(time.Hour),
+		refreshAt: now.Add(time.Hour - tokenRefreshMargin)}, token)
+	now = now.Add(time.Hour - tokenRefreshMargin - time.Second)
+	token, err = tokens.Token(context.Background(), "cc")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+
+	// Tokens are refreshed before they expire, so they do not expire on the way to the server
+	now = now.Add(2 * time.Second)
+	token, err = tokens.Token(context.Background(), "cc")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-2", token.AccessToken)
+
+	token, err = tokens.Token(context.Background(), "pw")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-3", token.AccessToken)
+
+	token, err = tokens.Token(context.Background(), "code")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-4", token.AccessToken)
+
+	srv.pending = 1
+	token, err = tokens.Token(context.Background(), "device")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-5", token.AccessToken)
+	assert.Contains(t, progress.String(), "open https://example.com/device and enter the code ABCD")
+
+	assert.Equal(t, []string{"client_credentials", "refresh_token", "password", "authorization_code", "urn:ietf:params:oauth:grant-type:device_code"}, srv.grants)
+
+	// Short-lived tokens are refreshed after 90% of their lifetime
+	srv.lifetime = 60
+	now = now.Add(2 * time.Hour)
+	token, err = tokens.Token(context.Background(), "pw")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-6", token.AccessToken)
+	assert.Equal(t, now.Add(54*time.Second), token.refreshAt)
+
+	for _, id := range []string{"missing", "saml"} {
+		_, err = tokens.Token(context.Background(), id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestTokenManagerLocksPerConfiguration(t *testing.T) {
+	srv := newTokenServer()
+	defer srv.Close()
+
+	tokens := NewTokenManager(map[string]AuthConfig{
+		"code": {GrantType: GrantAuthorizationCode, AuthURL: srv.URL + "/authorize", TokenURL: srv.URL + "/token", ClientID: "cli", PKCE: PKCE{Enabled: true}},
+		"cc":   {GrantType: GrantClientCredentials, TokenURL: srv.URL + "/token", ClientID: "cli", ClientSecret: "s3cret"},
+	})
+	browsing := make(chan string)
+	authorized := make(chan struct{})
+	tokens.browse = func(authURL string) error {
+		browsing <- authURL
+		<-authorized
+		resp, err := http.Get(authURL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := tokens.Token(context.Background(), "code")
+		done <- err
+	}()
+	<-browsing
+
+	// The user has not authorized the code grant yet, which must not block other configurations
+	token, err := tokens.Token(context.Background(), "cc")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+
+	close(authorized)
+	assert.NoError(t, <-done)
+}
+
+func TestAuthToken(t *testing.T) {
+	srv := newTokenServer()
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Token
+GET {{host}}/api
+Authorization: Bearer {{$auth.token("cc")}}
+
+### Missing configuration
+GET {{host}}/api
+Authorization: Bearer {{$auth.token("missing")}}
+`, map[string]string{"host": srv.URL})
+
+	client := New(1)
+	client.SetTokenManager(NewTokenManager(map[string]AuthConfig{
+		"cc": {GrantType: GrantClientCredentials, TokenURL: srv.URL + "/token", ClientID: "cli", ClientSecret: "s3cret"},
+	}))
+	redactor, err := NewRedactor(nil, nil)
+	assert.NoError(t, err)
+	client.SetRedactor(redactor)
+	client.SetErrorMode(ErrorModeContinue)
+	responses, err := client.Do(context.Background(), requests)
+	assert.Error(t, err)
+	assert.Equal(t, 200, responses[0].ReturnCode)
+	assert.Equal(t, "Bearer token-1", string(responses[0].Body))
+	if assert.NotNil(t, responses[1].Error) {
+		assert.Equal(t, ErrorKindAuth, responses[1].Error.Kind)
+	}
+
+	// Obtained tokens are redacted like the secrets of the environment
+	assert.Equal(t, "Bearer [redacted]", string(redactor.Response(responses[0]).Body))
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "environment")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	assert.NoError(t, ioutil.WriteFile(EnvironmentFileName, []byte(`{
+  "dev": {
+    "host": "https://dev.example.com",
+    "port": 8080,
+    "Security": {
+      "Auth": {
+        "api": {
+          "Type": "OAuth2",
+          "Grant Type": "Authorization Code",
+          "Auth URL": "{{host}}/authorize",
+          "Token URL": "{{host}}/token",
+          "Client ID": "cli",
+          "PKCE": {"Code Challenge Method": "Plain"}
+        }
+      }
+    }
+  }
+}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(PrivateEnvironmentFileName, []byte(`{
+  "dev": {
+    "secret": "s3cret",
+    "Security": {"Auth": {"api": {"Client Secret": "{{secret}}"}}}
+  }
+}`), 0644))
+
+	env, err := LoadEnvironment("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "https://dev.example.com", "port": "8080", "secret": "s3cret"}, env.Variables)
+	assert.Equal(t, map[string]AuthConfig{"api": {
+		Type:         "OAuth2",
+		GrantType:    GrantAuthorizationCode,
+		AuthURL:      "https://dev.example.com/authorize",
+		TokenURL:     "https://dev.example.com/token",
+		ClientID:     "cli",
+		ClientSecret: "s3cret",
+		PKCE:         PKCE{Enabled: true, Method: "Plain"},
+	}}, env.Auth)
+	assert.Equal(t, []string{"s3cret", "s3cret"}, env.Secrets)
+
+	_, err = LoadEnvironment("prod")
+	assert.Error(t, err)
+}
+
+func TestAuthProvider(t *testing.T) {
+	var mu sync.Mutex
+	logins, uses, forbidden := 0, 0, 0
+	session := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		logins++
+		session, uses = fmt.Sprintf("session-%d", logins), 0
+		fmt.Fprintf(w, `{"token":"%s"}`, session)
+	})
+	mux.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Sessions expire after two uses
+		uses++
+		if r.Header.Get("Authorization") != "Bearer "+session || uses > 2 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("/forbidden", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		forbidden++
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Login
+# @auth-provider
+POST {{host}}/login
+
+> {% client.global.set("session", response.body.token); %}
+
+### First
+GET {{host}}/data
+Authorization: Bearer {{session}}
+
+### Second
+GET {{host}}/data
+Authorization: Bearer {{session}}
+
+### Expired
+GET {{host}}/data
+Authorization: Bearer {{session}}
+
+### Forbidden
+GET {{host}}/forbidden
+Authorization: Bearer {{session}}
+`, map[string]string{"host": srv.URL})
+
+	client := New(1)
+	client.SetErrorMode(ErrorModeContinue)
+	responses, err := client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+	for i, status := range []int{200, 200, 200, 200, 401} {
+		assert.Equal(t, status, responses[i].ReturnCode, requests[i].Name)
+	}
+	assert.False(t, responses[2].Reauthenticated)
+	assert.True(t, responses[3].Reauthenticated)
+	assert.True(t, responses[4].Reauthenticated)
+	// The expired and the forbidden request re-ran the login, the forbidden request is repeated only once
+	assert.Equal(t, 3, logins)
+	assert.Equal(t, 2, forbidden)
+}
+
+func TestAuthProviderHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			fmt.Fprint(w, `{"token":"session-token"}`)
+		case "/other":
+			fmt.Fprint(w, `{"token":"other-token"}`)
+		default:
+			fmt.Fprint(w, r.Header.Get("Authorization"))
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "handler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requests := parseRequests(t, `### Login
+# @auth-provider
+POST {{host}}/login
+
+> {% client.global.set("session", response.body.token); %}
+
+>> {{dir}}/login.json
+
+### Other
+GET {{host}}/other
+
+> {% client.global.set("session", response.body.token); %}
+
+### Data
+GET {{host}}/data
+Authorization: Bearer {{session}}
+`, map[string]string{"host": srv.URL, "dir": dir})
+
+	redactor, err := NewRedactor(nil, nil)
+	assert.NoError(t, err)
+	client := New(1)
+	client.SetRedactor(redactor)
+	responses, err := client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+	// Only the handler of the auth provider is evaluated, reading the body from the file it was saved to
+	assert.Equal(t, "Bearer session-token", string(responses[2].Body))
+	assert.Equal(t, "Bearer [redacted]", redactor.String(string(responses[2].Body)))
+
+	requests = parseRequests(t, `### Login
+# @auth-provider
+POST {{host}}/login
+
+> {% client.global.set("session", response.body.missing); %}
+`, map[string]string{"host": srv.URL})
+	responses, err = New(1).Do(context.Background(), requests)
+	assert.Error(t, err)
+	if assert.NotNil(t, responses[0].Error) {
+		assert.Equal(t, ErrorKindScript, responses[0].Error.Kind)
+		assert.Equal(t, "can not set session: the body has no response.body.missing", responses[0].Error.Message)
+	}
+
+	// Handler files are read relative to the file of the request, not the working directory
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "login.js"), []byte(`client.global.set("session", response.body.token);`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "login.http"), []byte(`### Login
+# @auth-provider
+POST `+srv.URL+`/login
+
+> login.js
+
+### Data
+GET `+srv.URL+`/data
+Authorization: Bearer {{session}}
+`), 0644))
+	requests, err = parser.ParseFile(filepath.Join(dir, "login.http"), map[string]string{})
+	assert.NoError(t, err)
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(os.TempDir()))
+	defer os.Chdir(wd)
+	responses, err = New(1).Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer session-token", string(responses[1].Body))
+}
+
+func TestSubstitute(t *testing.T) {
+	requests := parseRequests(t, `### Upload
+POST https://example.com/{{tenant}}/upload
+Content-Type: multipart/form-data; boundary=WebAppBoundary
+
+--WebAppBoundary
+Content-Disposition: form-data; name="owner"
+X-Tenant: {{tenant}}
+
+{{user}}
+--WebAppBoundary
+Content-Disposition: form-data; name="data"; filename="data.json"
+Content-Type: application/json
+
+< ./{{user}}.json
+--WebAppBoundary--
+
+>> ./out/{{user}}.json
+`, nil)
+	vars := map[string]string{"tenant": "acme", "user": "alice"}
+	req, err := substitute(requests[0], func(text string) string {
+		return replaceVariables(text, vars)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/acme/upload", req.URL.Path)
+	if assert.Len(t, req.Parts, 2) {
+		assert.Equal(t, "acme", req.Parts[0].Headers["X-Tenant"])
+		assert.Equal(t, "alice", req.Parts[0].Body)
+		assert.Equal(t, "./alice.json", req.Parts[1].FileLoad)
+	}
+	assert.Equal(t, "./out/alice.json", req.Output.Path)
+
+	// The parsed request is left unchanged for later attempts
+	assert.Equal(t, "{{user}}", requests[0].Parts[0].Body)
+	assert.Equal(t, "./out/{{user}}.json", requests[0].Output.Path)
+
+	// Bodies loaded from files use the variables set by the auth provider and are relative to the file of the request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			fmt.Fprint(w, `{"user":"alice"}`)
+			return
+		}
+		_, _ = io.Copy(w, r.Body)
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "substitute")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "alice.json"), []byte(`{"name":"Alice"}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "upload.http"), []byte(`### Login
+# @auth-provider
+GET `+srv.URL+`/login
+
+> {% client.global.set("user", response.body.user); %}
+
+### Upload
+POST `+srv.URL+`/upload
+
+< {{user}}.json
+`), 0644))
+	requests, err = parser.ParseFile(filepath.Join(dir, "upload.http"), map[string]string{})
+	assert.NoError(t, err)
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(os.TempDir()))
+	defer os.Chdir(wd)
+	responses, err := New(1).Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"Alice"}`, string(responses[1].Body))
+}
+
+func TestHandlerValue(t *testing.T) {
+	resp := &Response{
+		ReturnCode: 201,
+		Header:     http.Header{"Location": {"/items/1"}},
+		Body:       []byte(`{"json":{"token":"abc","ids":[1,2]}}`),
+	}
+	for expr, expected := range map[string]string{
+		`"literal"`:                            "literal",
+		`'literal'`:                            "literal",
+		"response.status":                      "201",
+		`response.headers.valueOf("Location")`: "/items/1",
+		"response.body.json.token":             "abc",
+		"response.body.json.ids":               "[1,2]",
+		"response.body.json.ids[1]":            "2",
+		`response.body["json"].token`:          "abc",
+	} {
+		value, err := handlerValue(expr, resp)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, expected, value, expr)
+	}
+
+	for _, expr := range []string{"response.body.json.missing", "request.environment.get(\"host\")", "response.body.json.token.toUpperCase()"} {
+		_, err := handlerValue(expr, resp)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestErrorModes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### Broken
+# @name broken
+GET {{host}}/broken
+
+### Depends on broken
+# @depends-on broken
+GET {{host}}/dependent
+
+### Independent
+GET {{host}}/independent
+`, map[string]string{"host": srv.URL})
+
+	client := New(1)
+	client.SetFailOnStatus(StatusSet{{500, 599}})
+	responses, err := client.Do(context.Background(), requests)
+	assert.Error(t, err)
+	assert.Equal(t, `prerequisite "broken" failed`, responses[1].Skipped)
+	assert.Equal(t, http.StatusOK, responses[2].ReturnCode)
+
+	client.SetErrorMode(ErrorModeFailFast)
+	responses, err = client.Do(context.Background(), requests)
+	assert.Error(t, err)
+	assert.Equal(t, `run aborted after request "broken" failed`, responses[1].Skipped)
+	assert.Equal(t, `run aborted after request "broken" failed`, responses[2].Skipped)
+
+	client.SetErrorMode(ErrorModeContinue)
+	responses, err = client.Do(context.Background(), requests)
+	assert.EqualError(t, err, "1 error occurred:\n\t* request 0 got the failing status 500\n\n")
+	assert.Equal(t, http.StatusOK, responses[1].ReturnCode)
+	assert.Equal(t, http.StatusOK, responses[2].ReturnCode)
+
+	// Statuses not failing the run do not abort it either
+	client.SetErrorMode(ErrorModeFailFast)
+	client.SetFailOnStatus(StatusSet{{404, 404}})
+	responses, err = client.Do(context.Background(), requests)
+	assert.NoError(t, err)
+	assert.Empty(t, responses[1].Skipped)
+	assert.Empty(t, responses[2].Skipped)
+}
+
+func TestExitCode(t *testing.T) {
+	failOn, err := ParseStatusSet([]string{"4xx", "500-502"})
+	assert.NoError(t, err)
+	assert.True(t, failOn.Contains(404))
+	assert.True(t, failOn.Contains(502))
+	assert.False(t, failOn.Contains(503))
+	assert.False(t, failOn.Contains(200))
+
+	_, err = ParseStatusSet([]string{"9xx"})
+	assert.Error(t, err)
+	_, err = ParseStatusSet([]string{"502-500"})
+	assert.Error(t, err)
+
+	ok := Response{ReturnCode: 200}
+	tc := []struct {
+		responses []Response
+		failOn    StatusSet
+		code      int
+	}{
+		{responses: []Response{ok, {ReturnCode: 500}}, code: ExitOK},
+		{responses: []Response{ok, {ReturnCode: 500}}, failOn: failOn, code: ExitStatusFailure},
+		{responses: []Response{ok, {ReturnCode: 503}}, failOn: failOn, code: ExitOK},
+		{responses: []Response{{ReturnCode: 404}, {Skipped: "prerequisite failed"}}, code: ExitSkipped},
+		{responses: []Response{{ReturnCode: 404}, {Differences: []Difference{{Path: "$"}}}}, failOn: failOn, code: ExitAssertionFailure},
+		{responses: []Response{{Differences: []Difference{{Path: "$"}}}, {Error: &Error{Kind: ErrorKindDNS}}}, code: ExitTransportError},
+		{responses: []Response{ok, {ReturnCode: 200, Error: &Error{Kind: ErrorKindScript}}}, code: ExitAssertionFailure},
+		{responses: []Response{{Error: &Error{Kind: ErrorKindScript}}, {Error: &Error{Kind: ErrorKindConnect}}}, code: ExitTransportError},
+	}
+	for i, c := range tc {
+		assert.Equal(t, c.code, ExitCode(c.responses, c.failOn), "Test %d failed", i)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	requests := parseRequests(t, `### First
+GET {{host}}/first
+
+### Second
+GET {{host}}/second
+`, map[string]string{"host": srv.URL})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	responses, err := New(1).Do(ctx, requests)
+	assert.Error(t, err)
+	if assert.NotNil(t, responses[0].Error) {
+		assert.Equal(t, ErrorKindDeadline, responses[0].Error.Kind)
+	}
+	assert.Equal(t, "run deadline exceeded", responses[1].Skipped)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	responses, err = New(1).Do(ctx, requests)
+	assert.Error(t, err)
+	if assert.NotNil(t, responses[0].Error) {
+		assert.Equal(t, ErrorKindCanceled, responses[0].Error.Kind)
+	}
+	assert.Equal(t, "run interrupted", responses[1].Skipped)
+}
@@ -0,0 +1,32 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"intelirest-cli/parser"
+)
+
+// outputPath returns the file the response body of a request with an output redirection is saved to.
+// Without overwrite a number is added to the file name until it does not clash with an existing file.
+func outputPath(output *parser.OutputRedirect) (string, error) {
+	path := filepath.Clean(output.Path)
+	if output.Overwrite {
+		return path, nil
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 1; ; i++ {
+		if _, err := os.Stat(candidate); err != nil {
+			if os.IsNotExist(err) {
+				return candidate, nil
+			}
+			return "", err
+		}
+		candidate = base + "-" + strconv.Itoa(i) + ext
+	}
+}
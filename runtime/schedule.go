@@ -0,0 +1,135 @@
+package runtime
+
+import (
+	"sort"
+
+	"golang.org/x/net/publicsuffix"
+
+	"intelirest-cli/parser"
+)
+
+// dependencies returns the requests each request waits for. Besides the depends-on directives
+// parallel execution adds the implicit dependencies between requests.
+func dependencies(requests []parser.Request, parallel bool) [][]int {
+	deps := make([][]int, len(requests))
+	if parallel {
+		deps = implicitDependencies(requests)
+	}
+	for i, req := range requests {
+		deps[i] = append(deps[i], req.DependsOn...)
+	}
+	return deps
+}
+
+// implicitDependencies orders the requests which depend on the results of earlier requests.
+// Requests with response handlers may set global variables, so they run in file order and
+// requests using variables the environment does not define wait for all of them.
+// Requests saving their body to the same file run in file order too, and so do the requests using
+// the cookie jar for the same site, as an earlier request may set a cookie like a session.
+func implicitDependencies(requests []parser.Request) [][]int {
+	deps := make([][]int, len(requests))
+	handlers := make([]int, 0)
+	outputs := make(map[string]int)
+	jarUsers := make(map[string]int)
+	for i, req := range requests {
+		hasHandler := req.ResponseHandler != "" || req.ResponseHandlerFile != ""
+		if hasHandler || len(req.Variables()) > 0 {
+			deps[i] = append(deps[i], handlers...)
+		}
+		if hasHandler {
+			handlers = append(handlers, i)
+		}
+
+		if req.Output != nil {
+			if prev, ok := outputs[req.Output.Path]; ok {
+				deps[i] = append(deps[i], prev)
+			}
+			outputs[req.Output.Path] = i
+		}
+
+		if !req.HasOption(parser.OptionNoCookieJar) {
+			site := cookieSite(req.URL.Hostname())
+			if prev, ok := jarUsers[site]; ok && !dependsOn(deps[i], prev) {
+				deps[i] = append(deps[i], prev)
+			}
+			jarUsers[site] = i
+		}
+	}
+	return deps
+}
+
+func dependsOn(deps []int, j int) bool {
+	for _, d := range deps {
+		if d == j {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieSite returns the registrable domain of host, which cookies set for the host may apply to
+func cookieSite(host string) string {
+	if site, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return site
+	}
+	return host
+}
+
+// schedule runs the jobs 0 to n-1 on a pool of workers. A job starts once all jobs it depends
+// on have finished. Jobs which are ready at the same time start in the order of their index.
+func schedule(n, workers int, deps [][]int, run func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	pending := make([]int, n)
+	dependents := make([][]int, n)
+	for i, d := range deps {
+		pending[i] = len(d)
+		for _, j := range d {
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	ready := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	jobs := make(chan int)
+	finished := make(chan int)
+	defer close(jobs)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				run(i)
+				finished <- i
+			}
+		}()
+	}
+
+	running := 0
+	for done := 0; done < n; done++ {
+		for running < workers && len(ready) > 0 {
+			jobs <- ready[0]
+			ready = ready[1:]
+			running++
+		}
+		if running == 0 {
+			// Only jobs waiting on each other are left
+			return
+		}
+
+		i := <-finished
+		running--
+		for _, d := range dependents[i] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+		sort.Ints(ready)
+	}
+}
@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusSet is a set of HTTP status codes
+type StatusSet []statusRange
+
+type statusRange struct {
+	from, to int
+}
+
+// ParseStatusSet parses status codes given as single codes like 404, ranges like 500-504 or classes like 5xx
+func ParseStatusSet(specs []string) (StatusSet, error) {
+	set := make(StatusSet, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		if spec == "" {
+			continue
+		}
+
+		var r statusRange
+		var err error
+		switch {
+		case len(spec) == 3 && strings.HasSuffix(spec, "xx"):
+			var class int
+			class, err = strconv.Atoi(spec[:1])
+			r = statusRange{from: class * 100, to: class*100 + 99}
+		case strings.Contains(spec, "-"):
+			bounds := strings.SplitN(spec, "-", 2)
+			if r.from, err = strconv.Atoi(bounds[0]); err == nil {
+				r.to, err = strconv.Atoi(bounds[1])
+			}
+		default:
+			r.from, err = strconv.Atoi(spec)
+			r.to = r.from
+		}
+
+		if err != nil || r.from < 100 || r.to > 599 || r.from > r.to {
+			return nil, fmt.Errorf("invalid status code specification \"%s\"", spec)
+		}
+		set = append(set, r)
+	}
+	return set, nil
+}
+
+// Contains reports whether code is part of the set
+func (s StatusSet) Contains(code int) bool {
+	for _, r := range s {
+		if r.from <= code && code <= r.to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings breaks the time a request took down into its phases. Phases which did not happen, like
+// connecting on a reused connection, are zero. Phases of redirects are added up.
+type Timings struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// FirstByte is the time from starting the request until the first byte of the final response arrived
+	FirstByte time.Duration
+	Total     time.Duration
+	// Reused reports whether the final request was sent on a connection of an earlier request
+	Reused bool `json:",omitempty"`
+}
+
+// timingRecorder collects the Timings of a single attempt from the events of an httptrace.ClientTrace
+type timingRecorder struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	timings      Timings
+}
+
+func newTimingRecorder() *timingRecorder {
+	return &timingRecorder{start: time.Now()}
+}
+
+func (r *timingRecorder) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timings.DNS += time.Since(r.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			// Dialing several addresses in parallel counts from the first attempt
+			if r.connectStart.IsZero() {
+				r.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if err == nil && !r.connectStart.IsZero() {
+				r.timings.Connect += time.Since(r.connectStart)
+				r.connectStart = time.Time{}
+			}
+		},
+		TLSHandshakeStart: func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timings.TLSHandshake += time.Since(r.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timings.Reused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timings.FirstByte = time.Since(r.start)
+		},
+	}
+}
+
+// finish returns the timings with the total time up to now
+func (r *timingRecorder) finish() *Timings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	timings := r.timings
+	timings.Total = time.Since(r.start)
+	return &timings
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"intelirest-cli/runtime"
+	"io"
+	"text/tabwriter"
+)
+
+// printTimings writes the timing breakdown of the responses as table
+func printTimings(out io.Writer, responses []runtime.Response) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REQUEST\tSTATUS\tDNS\tCONNECT\tTLS\tFIRST BYTE\tTOTAL")
+	for _, resp := range responses {
+		status := fmt.Sprint(resp.ReturnCode)
+		switch {
+		case resp.Skipped != "":
+			status = "skipped"
+		case resp.Error != nil:
+			status = string(resp.Error.Kind)
+		}
+
+		t := resp.Timings
+		if t == nil {
+			fmt.Fprintf(w, "%s %s\t%s\t-\t-\t-\t-\t-\n", resp.Method, resp.Name, status)
+			continue
+		}
+		fmt.Fprintf(w, "%s %s\t%s\t%s\t%s\t%s\t%s\t%s\n", resp.Method, resp.Name, status,
+			runtime.FormatDuration(t.DNS), runtime.FormatDuration(t.Connect), runtime.FormatDuration(t.TLSHandshake),
+			runtime.FormatDuration(t.FirstByte), runtime.FormatDuration(t.Total))
+	}
+	return w.Flush()
+}